@@ -0,0 +1,201 @@
+//go:build linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	PR_CAPBSET_DROP      = 24
+	PR_CAP_AMBIENT       = 47
+	PR_CAP_AMBIENT_LOWER = 3
+
+	LINUX_CAPABILITY_VERSION_3 = 0x20080522
+)
+
+// capabilityNames maps each capability to its number.  See capabilities(7).
+var capabilityNames = map[string]uint{
+	"CAP_CHOWN":              0,
+	"CAP_DAC_OVERRIDE":       1,
+	"CAP_DAC_READ_SEARCH":    2,
+	"CAP_FOWNER":             3,
+	"CAP_FSETID":             4,
+	"CAP_KILL":               5,
+	"CAP_SETGID":             6,
+	"CAP_SETUID":             7,
+	"CAP_SETPCAP":            8,
+	"CAP_LINUX_IMMUTABLE":    9,
+	"CAP_NET_BIND_SERVICE":   10,
+	"CAP_NET_BROADCAST":      11,
+	"CAP_NET_ADMIN":          12,
+	"CAP_NET_RAW":            13,
+	"CAP_IPC_LOCK":           14,
+	"CAP_IPC_OWNER":          15,
+	"CAP_SYS_MODULE":         16,
+	"CAP_SYS_RAWIO":          17,
+	"CAP_SYS_CHROOT":         18,
+	"CAP_SYS_PTRACE":         19,
+	"CAP_SYS_PACCT":          20,
+	"CAP_SYS_ADMIN":          21,
+	"CAP_SYS_BOOT":           22,
+	"CAP_SYS_NICE":           23,
+	"CAP_SYS_RESOURCE":       24,
+	"CAP_SYS_TIME":           25,
+	"CAP_SYS_TTY_CONFIG":     26,
+	"CAP_MKNOD":              27,
+	"CAP_LEASE":              28,
+	"CAP_AUDIT_WRITE":        29,
+	"CAP_AUDIT_CONTROL":      30,
+	"CAP_SETFCAP":            31,
+	"CAP_MAC_OVERRIDE":       32,
+	"CAP_MAC_ADMIN":          33,
+	"CAP_SYSLOG":             34,
+	"CAP_WAKE_ALARM":         35,
+	"CAP_BLOCK_SUSPEND":      36,
+	"CAP_AUDIT_READ":         37,
+	"CAP_PERFMON":            38,
+	"CAP_BPF":                39,
+	"CAP_CHECKPOINT_RESTORE": 40,
+}
+
+// user_cap_header_struct and user_cap_data_struct of capget(2).
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// parseCapabilities
+//
+//  Parse a comma separated list of capabilities, e.g. CAP_NET_RAW,sys_admin.
+//  Names are case-insensitive and the CAP_ prefix is optional.  ALL stands for
+//  every capability.
+//
+func parseCapabilities(list string) (map[uint]bool, error) {
+	caps := map[uint]bool{}
+
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+
+		if name == "ALL" {
+			for _, c := range capabilityNames {
+				caps[c] = true
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(name, "CAP_") {
+			name = "CAP_" + name
+		}
+
+		c, ok := capabilityNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown capability (%s)", name)
+		}
+
+		caps[c] = true
+	}
+
+	return caps, nil
+}
+
+// capabilitiesToDrop
+//
+//  Work out which capabilities the app loses from --drop-capabilities, or
+//  from --keep-capabilities, which drops every capability not listed.
+//
+func capabilitiesToDrop(options map[string]string) ([]uint, error) {
+	drop, keep := options["drop-capabilities"], options["keep-capabilities"]
+
+	if drop != "" && keep != "" {
+		return nil, fmt.Errorf("flags --drop-capabilities and --keep-capabilities cannot be used together")
+	}
+
+	name, list := "drop-capabilities", drop
+	if keep != "" {
+		name, list = "keep-capabilities", keep
+	}
+
+	if list == "" {
+		return nil, nil
+	}
+
+	caps, err := parseCapabilities(list)
+	if err != nil {
+		return nil, fmt.Errorf("flag --%s has an %v", name, err)
+	}
+
+	// keeping a capability means dropping all the others.
+	invert := name == "keep-capabilities"
+
+	result := []uint{}
+
+	for _, c := range capabilityNames {
+		if caps[c] != invert {
+			result = append(result, c)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, nil
+}
+
+// dropCapabilities
+//
+//  Remove caps from the bounding set, so exec cannot grant them back, and from
+//  our effective, permitted, inheritable and ambient sets.  Capabilities the
+//  kernel does not know are skipped.
+//
+func dropCapabilities(caps []uint) error {
+	for _, c := range caps {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_CAPBSET_DROP, uintptr(c), 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("cannot drop capability (%d) from bounding set: %v", c, errno)
+		}
+
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, PR_CAP_AMBIENT, PR_CAP_AMBIENT_LOWER, uintptr(c), 0, 0, 0); errno != 0 && errno != syscall.EINVAL {
+			return fmt.Errorf("cannot drop capability (%d) from ambient set: %v", c, errno)
+		}
+	}
+
+	header := capHeader{version: LINUX_CAPABILITY_VERSION_3}
+	data := [2]capData{}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("cannot read capabilities: %v", errno)
+	}
+
+	for _, c := range caps {
+		mask := ^uint32(1 << (c % 32))
+		data[c/32].effective &= mask
+		data[c/32].permitted &= mask
+		data[c/32].inheritable &= mask
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("cannot set capabilities: %v", errno)
+	}
+
+	return nil
+}
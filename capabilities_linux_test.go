@@ -0,0 +1,80 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := parseCapabilities("CAP_NET_RAW, sys_admin")
+	if err != nil {
+		t.Fatalf("parseCapabilities: %v", err)
+	}
+
+	if len(caps) != 2 || !caps[capabilityNames["CAP_NET_RAW"]] || !caps[capabilityNames["CAP_SYS_ADMIN"]] {
+		t.Errorf("got %v; want CAP_NET_RAW and CAP_SYS_ADMIN", caps)
+	}
+
+	if _, err := parseCapabilities("CAP_NET_RAW,CAP_BOGUS"); err == nil || !strings.Contains(err.Error(), "CAP_BOGUS") {
+		t.Errorf("got error %v; want it to name CAP_BOGUS", err)
+	}
+}
+
+func TestDropCapabilities(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to hold CAP_NET_RAW")
+	}
+
+	bounding := func(stdout string) uint64 {
+		t.Helper()
+
+		for _, line := range strings.Split(stdout, "\n") {
+			if strings.HasPrefix(line, "CapBnd:") {
+				set, err := strconv.ParseUint(strings.TrimSpace(line[len("CapBnd:"):]), 16, 64)
+				if err != nil {
+					t.Fatalf("cannot parse %q: %v", line, err)
+				}
+				return set
+			}
+		}
+
+		t.Fatalf("no CapBnd line in %q", stdout)
+		return 0
+	}
+
+	netRaw := uint64(1) << capabilityNames["CAP_NET_RAW"]
+
+	stdout, stderr, code := runMain(t, "", "--", "/bin/cat", "/proc/self/status")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+	if bounding(stdout)&netRaw == 0 {
+		t.Skip("CAP_NET_RAW is not in our bounding set")
+	}
+
+	stdout, stderr, code = runMain(t, "", "--drop-capabilities", "net_raw", "--", "/bin/cat", "/proc/self/status")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+	if bounding(stdout)&netRaw != 0 {
+		t.Errorf("app still has CAP_NET_RAW in its bounding set")
+	}
+
+	_, stderr, code = runMain(t, "", "--drop-capabilities", "CAP_BOGUS", "--", "/bin/true")
+	if code != int(BadFlag) {
+		t.Errorf("got code %d; want %d\nstderr: %s", code, BadFlag, stderr)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// copyInto copies file, following links, to the same path under root.
+func copyInto(t *testing.T, root string, file string) {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(root, file)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dest, data, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChroot(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to chroot")
+	}
+
+	libs, err := exec.Command("ldd", "/bin/sh").Output()
+	if err != nil {
+		t.Skipf("cannot list the libraries of /bin/sh: %v", err)
+	}
+
+	// a root with just /bin/sh, the libraries it needs, and a marker.
+	root := t.TempDir()
+	copyInto(t, root, "/bin/sh")
+
+	for _, field := range strings.Fields(string(libs)) {
+		if strings.HasPrefix(field, "/") {
+			copyInto(t, root, field)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "marker"), []byte("inside\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	script := `read line < /marker; echo "$line"; [ -e /etc/passwd ] && echo "host /etc/passwd"; echo "cwd $(pwd)"`
+
+	stdout, stderr, code := runMain(t, "", "--chroot", root, "--", "/bin/sh", "-c", script)
+	if want := "inside\ncwd /\n"; code != int(OK) || stdout != want {
+		t.Errorf("got code %d, stdout %q; want 0, %q\nstderr: %s", code, stdout, want, stderr)
+	}
+
+	// --chdir is inside the new root too.
+	stdout, stderr, code = runMain(t, "", "--chroot", root, "--chdir", "srv/app", "--chdir-create", "--", "/bin/sh", "-c", "pwd")
+	if want := "/srv/app\n"; code != int(OK) || stdout != want {
+		t.Errorf("chdir: got code %d, stdout %q; want 0, %q\nstderr: %s", code, stdout, want, stderr)
+	}
+	if info, err := os.Stat(filepath.Join(root, "srv", "app")); err != nil || !info.IsDir() {
+		t.Errorf("chdir: --chdir-create did not create the directory inside the root (%v)", err)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// setupChroot
+//
+//  Run the app with dir as its root directory.  The app's path, and its
+//  working directory, are then taken inside dir.  Without a working directory,
+//  the app starts in dir itself.  Changing root needs CAP_SYS_CHROOT, which
+//  Start reports if we lack it.
+//
+func setupChroot(cmd *exec.Cmd, dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("cannot use chroot directory: %v", err)
+	}
+
+	info, err := os.Stat(abs)
+	if err != nil {
+		return fmt.Errorf("cannot use chroot directory: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("chroot (%s) is not a directory", dir)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Chroot = abs
+
+	// ours would be outside dir, a way out of it.
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+
+	return nil
+}
@@ -0,0 +1,255 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// loadJSONConfig
+//
+//  Read the options in a --json-config file into options, keeping those given
+//  on the command line.  The file is a JSON object keyed by flag name, plus
+//  "command" for the app's argv, e.g.
+//
+//    {
+//      "stop-signals": "SIGTERM:10s,SIGKILL:0",
+//      "trap-all": true,
+//      "init-log": ["/var/log/app.log"],
+//      "command": ["/app/server", "--port", "80"]
+//    }
+//
+//  Errors name the JSON path of the bad value, e.g. $.trap-all.
+//
+func loadJSONConfig(file string, options map[string]string) ([]string, error) {
+	var (
+		command []string
+		config  map[string]json.RawMessage
+	)
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("not valid JSON")
+	}
+
+	if err = json.Unmarshal(data, &config); err != nil || config == nil {
+		return nil, fmt.Errorf("$ must be a JSON object")
+	}
+
+	infos := map[string]FlagInfo{}
+	for _, info := range flagInfos {
+		infos[info.Name] = info
+	}
+
+	// report the first error the same way every time.
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := "$." + key
+
+		if key == "command" {
+			if err := json.Unmarshal(config[key], &command); err != nil || len(command) == 0 {
+				return nil, fmt.Errorf("%s must be a non-empty array of strings", path)
+			}
+			continue
+		}
+
+		info, found := infos[key]
+
+		switch key {
+		case "error-format", "help", "help-json", "json-config", "version":
+			// command line only.
+			found = false
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s is not a known option", path)
+		}
+
+		value, err := configValue(info, config[key], path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, set := options[key]; !set && value != "" {
+			options[key] = value
+		}
+	}
+
+	return command, nil
+}
+
+// configValue
+//
+//  Convert one option of a --json-config file to its options form: true or
+//  false for a flag without a parameter, a string or number for its parameter,
+//  or an array of them for a repeatable flag.
+//
+func configValue(info FlagInfo, raw json.RawMessage, path string) (string, error) {
+	if info.Param == "" {
+		var set bool
+
+		if err := json.Unmarshal(raw, &set); err != nil {
+			return "", fmt.Errorf("%s must be true or false", path)
+		}
+
+		if set {
+			return "true", nil
+		}
+		return "", nil
+	}
+
+	var list []json.RawMessage
+
+	if info.repeatable() && json.Unmarshal(raw, &list) == nil {
+		values := make([]string, len(list))
+
+		for i := range list {
+			value, err := configParam(list[i], fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return "", err
+			}
+			values[i] = value
+		}
+
+		return strings.Join(values, "\x00"), nil
+	}
+
+	return configParam(raw, path)
+}
+
+// configParam reads one parameter, a non-empty string or a number.
+func configParam(raw json.RawMessage, path string) (string, error) {
+	var (
+		number json.Number
+		text   string
+	)
+
+	if json.Unmarshal(raw, &text) == nil {
+		if text == "" || strings.ContainsRune(text, 0) {
+			return "", fmt.Errorf("%s must not be empty or hold NUL", path)
+		}
+		return text, nil
+	}
+
+	if json.Unmarshal(raw, &number) == nil {
+		return number.String(), nil
+	}
+
+	return "", fmt.Errorf("%s must be a string or a number", path)
+}
+
+// effectiveConfig
+//
+//  Collect the options in effect, from the command line and any --json-config,
+//  in --json-config form, so they can be saved and reused as one.  Flags that
+//  are off are left out.  defaults holds what usage() shows as the default of
+//  each flag not given.
+//
+func effectiveConfig(options map[string]string, command []string) (config map[string]interface{}, defaults map[string]interface{}) {
+	config, defaults = map[string]interface{}{}, map[string]interface{}{}
+
+	for _, info := range flagInfos {
+		switch info.Name {
+		case "error-format", "help", "help-json", "json-config", "version":
+			// command line only.
+			continue
+		}
+
+		value := options[info.Name]
+
+		switch {
+		case value == "":
+			if def := flagDefault(info); def != "" {
+				defaults[info.Name] = def
+			}
+		case info.Param == "":
+			config[info.Name] = true
+		case info.repeatable():
+			config[info.Name] = strings.Split(value, "\x00")
+		default:
+			config[info.Name] = value
+		}
+	}
+
+	if len(command) > 0 {
+		config["command"] = command
+	}
+
+	return config, defaults
+}
+
+// flagDefault returns the default in info's description, e.g. "10s" for
+// "(default: 10s)", or "" if it has none.
+func flagDefault(info FlagInfo) string {
+	_, def, found := strings.Cut(info.Description, "(default: ")
+	if !found {
+		return ""
+	}
+
+	def, _, _ = strings.Cut(def, ")")
+
+	return def
+}
+
+// printConfig
+//
+//  Log the effective config for --print-config as one record: a JSON object
+//  with --error-format json, else one line of name=value pairs in name order,
+//  each value as JSON, then the defaults.
+//
+func printConfig(options map[string]string, command []string) {
+	config, defaults := effectiveConfig(options, command)
+
+	if errorFormat == "json" {
+		enc := json.NewEncoder(sharedStderr)
+		enc.SetEscapeHTML(false)
+		enc.Encode(struct {
+			Config   map[string]interface{} `json:"config"`
+			Defaults map[string]interface{} `json:"defaults"`
+		}{config, defaults})
+		return
+	}
+
+	log.Printf("Effective config (%s; defaults: %s).", configPairs(config), configPairs(defaults))
+}
+
+// configPairs joins name=value pairs of values, in name order, each value as
+// JSON.
+func configPairs(values map[string]interface{}) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value, _ := json.Marshal(values[name])
+		pairs[i] = name + "=" + string(value)
+	}
+
+	return strings.Join(pairs, ", ")
+}
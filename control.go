@@ -0,0 +1,131 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// listenControl
+//
+//  Listen on the --control-socket at path, replacing a stale socket left by a
+//  run that did not exit cleanly.  Only our own user may connect: the socket is
+//  made mode 0600.  Closing the listener removes the socket.
+//
+func listenControl(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("control socket (%s) exists and is not a socket", path)
+		}
+
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket (%s) is in use", path)
+		}
+
+		os.Remove(path)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on control socket (%s): %v", path, err)
+	}
+
+	if err = os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("cannot restrict control socket (%s): %v", path, err)
+	}
+
+	return l, nil
+}
+
+// serveControl
+//
+//  Answer commands on l, one per line, until l is closed:
+//
+//    signal NAME - send the app signal NAME, as if forwarded.
+//    status      - say the app's pid and uptime.
+//    restart     - refused; docker-run-app never restarts the app.
+//
+//  Each command gets one line back, "ok" or "error: ..." for signal and
+//  restart.  Signals are sent on the returned channel for runCommand to
+//  deliver, until stopping is closed, after which they are refused.
+//
+func serveControl(l net.Listener, pid int, started time.Time, stopping <-chan struct{}) <-chan os.Signal {
+	signals := make(chan os.Signal, 1)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			} else if err != nil {
+				log.Printf("Cannot accept on control socket: %v", err)
+				continue
+			}
+
+			go handleControl(conn, signals, pid, started, stopping)
+		}
+	}()
+
+	return signals
+}
+
+func handleControl(conn net.Conn, signals chan<- os.Signal, pid int, started time.Time, stopping <-chan struct{}) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var reply string
+
+		switch {
+		case fields[0] == "signal" && len(fields) == 2:
+			if sig, err := parseSignal(fields[1]); err != nil {
+				reply = fmt.Sprintf("error: %v", err)
+			} else {
+				log.Printf("Control socket asked to signal app (%v).", sig)
+
+				// no one reads signals once runCommand stops the app.
+				select {
+				case signals <- sig:
+					reply = "ok"
+				case <-stopping:
+					reply = "error: app is stopping"
+				}
+			}
+		case fields[0] == "status" && len(fields) == 1:
+			reply = fmt.Sprintf("running (pid %d, uptime %v)", pid, time.Since(started).Round(time.Second))
+		case fields[0] == "restart" && len(fields) == 1:
+			reply = "error: docker-run-app never restarts the app"
+		default:
+			reply = fmt.Sprintf("error: unknown command (%s)", scanner.Text())
+		}
+
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
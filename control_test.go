@@ -0,0 +1,55 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestControlSignalWhileStopping(t *testing.T) {
+	l, err := listenControl(filepath.Join(t.TempDir(), "control.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	stopping := make(chan struct{})
+	serveControl(l, 1, time.Now(), stopping)
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	replies := bufio.NewReader(conn)
+
+	// no one reads the signals: the first fills the channel, the second
+	// waits for room until we stop.
+	fmt.Fprintln(conn, "signal SIGHUP")
+	if reply, _ := replies.ReadString('\n'); reply != "ok\n" {
+		t.Fatalf("got %q; want \"ok\"", reply)
+	}
+
+	fmt.Fprintln(conn, "signal SIGHUP")
+	time.AfterFunc(50*time.Millisecond, func() { close(stopping) })
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if reply, _ := replies.ReadString('\n'); reply != "error: app is stopping\n" {
+		t.Errorf("got %q; want \"error: app is stopping\"", reply)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+// FlagInfo describes one flag for parseFlags, usage() and --help-json.
+type FlagInfo struct {
+	Name        string   // key of the flag's value in options
+	Flags       []string // every spelling of the flag, short first
+	Param       string   // name of the flag's parameter, "" if it takes none
+	Description string
+}
+
+// flagInfos lists every flag in the order usage() prints them.
+var flagInfos = []FlagInfo{
+	{"allow-no-command", []string{"--allow-no-command"}, "", "if COMMAND is not given, reap orphaned processes until SIGINT or SIGTERM."},
+	{"chdir", []string{"--chdir"}, "DIR", "run app from DIR."},
+	{"chdir-create", []string{"--chdir-create"}, "", "create DIR of --chdir if it is missing."},
+	{"chdir-mode", []string{"--chdir-mode"}, "MODE", "create DIR with octal MODE, less umask. (default: 0755)"},
+	{"chroot", []string{"--chroot"}, "DIR", "run app with DIR as its root directory. COMMAND is a path inside DIR. (Unix)"},
+	{"clear-env", []string{"--clear-env"}, "", "start app with only PATH and HOME from our environment."},
+	{"clear-env-strict", []string{"--clear-env-strict"}, "", "start app with an empty environment."},
+	{"colorize-child-levels", []string{"--colorize-child-levels"}, "", "on a terminal, color app's output lines by the level they start with (e.g. ERROR)."},
+	{"command-from-stdin", []string{"--command-from-stdin"}, "", "if COMMAND is not given, read it from the first line of stdin."},
+	{"control-socket", []string{"--control-socket"}, "PATH", "accept commands (signal NAME, status) on unix socket PATH."},
+	{"count-output", []string{"--count-output"}, "", "count bytes and lines of app's stdout and stderr, and log them on exit."},
+	{"cwd-from-command", []string{"--cwd-from-command"}, "", "run app from the directory of COMMAND, if it is a path."},
+	{"deadline", []string{"--deadline"}, "DURATION", "stop app, and exit with code 73, once DURATION has passed since we started."},
+	{"drain-message", []string{"--drain-message"}, "TEXT", "line sent to drain socket. (default: drain)"},
+	{"drain-socket", []string{"--drain-socket"}, "PATH", "on shutdown, drain app through unix socket PATH first."},
+	{"drain-timeout", []string{"--drain-timeout"}, "DURATION", "wait DURATION for app to drain. (default: 10s)"},
+	{"drop-capabilities", []string{"--drop-capabilities"}, "LIST", "drop LIST of capabilities (e.g. CAP_NET_RAW,SYS_ADMIN) from app. (Linux)"},
+	{"error-format", []string{"--error-format"}, "FORMAT", "report fatal errors as text or json. (default: text)"},
+	{"exec-delay", []string{"--exec-delay"}, "DURATION", "same as --start-delay."},
+	{"expand-args", []string{"--expand-args"}, "", "expand $VAR and ${VAR} in COMMAND and its args."},
+	{"expand-strict", []string{"--expand-strict"}, "", "with --expand-args, fail if a variable is not set."},
+	{"fail-fast", []string{"--fail-fast"}, "", "check that COMMAND exists and is executable before anything else."},
+	{"fail-on-stderr", []string{"--fail-on-stderr"}, "REGEX", "stop app if a line it writes to stderr matches REGEX."},
+	{"forward-all-signals", []string{"--forward-all-signals"}, "", "forward every signal to app. SIGINT and SIGTERM stop app."},
+	{"heartbeat-interval", []string{"--heartbeat-interval"}, "DURATION", "log that app is still running every DURATION."},
+	{"help", []string{"-h", "--help"}, "", "print this help message."},
+	{"help-json", []string{"--help-json"}, "", "print flags as JSON."},
+	{"hold-open-on-exit", []string{"--hold-open-on-exit"}, "", "after app exits, keep running until SIGTERM or SIGINT."},
+	{"ignore-shutdown-exit-code", []string{"--ignore-shutdown-exit-code"}, "", "exit 0 even if app exits with an error once signaled to stop."},
+	{"ignore-sigpipe", []string{"--ignore-sigpipe"}, "", "keep app alive once no one reads our output: ignore SIGPIPE, and discard app's output."},
+	{"init-log", []string{"--init-log"}, "FILE", "write docker-run-app output to FILE (e.g. app-{date}-{pid}.log, stdout, syslog:local). (repeatable)"},
+	{"init-log-dir-mode", []string{"--init-log-dir-mode"}, "MODE", "create log directories with octal MODE, less umask. (default: 0755)"},
+	{"init-log-mkdir", []string{"--init-log-mkdir"}, "", "create missing directories of --init-log files."},
+	{"json-config", []string{"--json-config"}, "FILE", "read options, and COMMAND, from JSON object in FILE. flags win."},
+	{"keep-capabilities", []string{"--keep-capabilities"}, "LIST", "drop every capability but LIST from app. (Linux)"},
+	{"kill-exit-code", []string{"--kill-exit-code"}, "CODE", "exit with CODE, not 65, if app had to be killed once signaled to stop."},
+	{"lock-file", []string{"--lock-file"}, "FILE", "take an exclusive lock on FILE, or exit if another instance holds it. (Unix)"},
+	{"log-invocation", []string{"--log-invocation"}, "", "log COMMAND's path, argv, directory and environment, with secrets masked, and the options given."},
+	{"max-line", []string{"--max-line"}, "BYTES", "split app's output lines longer than BYTES when handling it by line. (default: 65536)"},
+	{"max-open-files", []string{"--max-open-files"}, "N", "limit app to N open files (RLIMIT_NOFILE). (Linux)"},
+	{"merge-stderr", []string{"--merge-stderr"}, "", "write app's stderr to our stdout, interleaved with its stdout."},
+	{"min-runtime", []string{"--min-runtime"}, "DURATION", "warn if app exits on its own, even cleanly, within DURATION of starting."},
+	{"no-auto-env", []string{"--no-auto-env"}, "", "do not set DRA_VERSION, DRA_COMMAND and DRA_STARTED_AT for app."},
+	{"no-color", []string{"--no-color"}, "", "do not color docker-run-app's log on a terminal."},
+	{"no-force-kill", []string{"--no-force-kill"}, "", "never kill app; leave it to docker if it ignores stop signals."},
+	{"no-new-privileges", []string{"--no-new-privileges"}, "", "prevent app from gaining privileges (e.g. setuid)."},
+	{"no-search-path", []string{"--no-search-path"}, "", "do not look up COMMAND in PATH; a bare name is then a file in the working directory."},
+	{"pass-fd", []string{"--pass-fd"}, "N", "pass our open file descriptor N to app as 3, 4, ... in order, and set LISTEN_FDS. (repeatable)"},
+	{"post-start", []string{"--post-start"}, "CMD", "run CMD with /bin/sh -c once app has started."},
+	{"post-start-required", []string{"--post-start-required"}, "", "stop app if the --post-start CMD fails."},
+	{"post-start-signal", []string{"--post-start-signal"}, "SIG", "send SIG (e.g. SIGCONT) to app once it starts."},
+	{"post-stop", []string{"--post-stop"}, "CMD", "run CMD with /bin/sh -c after a signal has stopped app."},
+	{"post-stop-timeout", []string{"--post-stop-timeout"}, "DURATION", "kill the --post-stop CMD after DURATION. (default: 10s)"},
+	{"print-config", []string{"--print-config"}, "", "log the options in effect, after --json-config and defaults, before starting app."},
+	{"report-usage", []string{"--report-usage"}, "", "log CPU time and max RSS of app and docker-run-app on exit."},
+	{"sd-notify", []string{"--sd-notify"}, "", "relay app's sd_notify READY/WATCHDOG messages to NOTIFY_SOCKET. (Linux)"},
+	{"seccomp-profile", []string{"--seccomp-profile"}, "FILE", "apply seccomp BPF filter in FILE to app. (Linux)"},
+	{"shutdown-budget", []string{"--shutdown-budget"}, "DURATION", "fit all of shutdown in DURATION, then kill app."},
+	{"signal-buffer", []string{"--signal-buffer"}, "N", "queue up to N received signals while busy; more are dropped. (default: 1)"},
+	{"signal-map", []string{"--signal-map"}, "LIST", "send app signal TO for each signal FROM we receive, from LIST of FROM=TO (e.g. SIGINT=SIGTERM,SIGTERM=SIGQUIT)."},
+	{"start-delay", []string{"--start-delay"}, "DURATION", "wait DURATION (e.g. 1.5s) before starting app."},
+	{"start-retries", []string{"--start-retries"}, "N", "retry starting app N times if its file is missing or busy."},
+	{"start-retry-delay", []string{"--start-retry-delay"}, "DURATION", "wait DURATION between start retries. (default: 1s)"},
+	{"stderr-level", []string{"--stderr-level"}, "LEVEL", "with --tag-streams, give app's stderr lines LEVEL (e.g. warn, error)."},
+	{"stop-on-stdin-close", []string{"--stop-on-stdin-close"}, "", "forward stdin to app, and stop app if it runs 2s after stdin closes."},
+	{"stop-pidfile", []string{"--stop-pidfile"}, "FILE", "on shutdown, also stop the process whose pid is in FILE."},
+	{"stop-signal-env", []string{"--stop-signal-env"}, "NAME", "stop app with the signal in variable NAME (e.g. SIGQUIT) first, if set."},
+	{"stop-signals", []string{"--stop-signals"}, "LIST", "stop app with LIST of SIG[:TIMEOUT] (e.g. SIGTERM:10s,SIGKILL:0)."},
+	{"syslog", []string{"--syslog"}, "FACILITY", "also log to the local syslog in FACILITY (e.g. daemon, local0). (Linux)"},
+	{"syslog-include-app", []string{"--syslog-include-app"}, "", "with --syslog, also send app's output to syslog, stderr lines as errors."},
+	{"syslog-tag", []string{"--syslog-tag"}, "TAG", "tag our syslog messages with TAG. (default: docker-run-app)"},
+	{"tag-streams", []string{"--tag-streams"}, "", "write app's output as JSON lines tagged with their stream."},
+	{"tolerate-escalation", []string{"--tolerate-escalation"}, "", "exit 0 if app stops only after a later stop signal, or a kill."},
+	{"trace-args", []string{"--trace-args"}, "", "log COMMAND's path and each of its args, quoted, before starting it."},
+	{"trace-fd", []string{"--trace-fd"}, "N", "write how long each startup and shutdown phase took to file descriptor N, as JSON lines."},
+	{"trap-all", []string{"--trap-all"}, "", "forward every signal to app. only SIGTERM stops app."},
+	{"validate-json-stdout", []string{"--validate-json-stdout"}, "", "warn about each line app writes to stdout that is not JSON."},
+	{"version", []string{"-V", "--version"}, "", "print version info."},
+	{"watch-action", []string{"--watch-action"}, "ACTION", "what to do when --watch-file changes: reload-signal. (default: reload-signal)"},
+	{"watch-file", []string{"--watch-file"}, "FILE", "watch FILE, e.g. a mounted config, and act when it changes."},
+	{"watch-signal", []string{"--watch-signal"}, "SIG", "with --watch-action reload-signal, send SIG to app. (default: SIGHUP)"},
+	{"wrap", []string{"--wrap"}, "WRAPPER", "run COMMAND as: WRAPPER -- COMMAND (e.g. \"strace -f\")."},
+}
+
+// repeatableFlags lists flags that may be given more than once.  Their values
+// are read with optionList.
+var repeatableFlags = map[string]bool{
+	"init-log": true,
+	"pass-fd":  true,
+}
+
+// paramCount is the number of parameters eatFlag must eat for this flag.
+func (info FlagInfo) paramCount() int {
+	if info.Param == "" {
+		return 0
+	}
+	return 1
+}
+
+// repeatable is true if the flag may be given more than once.
+func (info FlagInfo) repeatable() bool {
+	return repeatableFlags[info.Name]
+}
+
+// usageName is the flag as shown in usage(), e.g. "-h, --help" or "--init-log FILE".
+func (info FlagInfo) usageName() string {
+	name := ""
+
+	for i, flag := range info.Flags {
+		if i > 0 {
+			name += ", "
+		}
+		name += flag
+	}
+
+	if info.Param != "" {
+		name += " " + info.Param
+	}
+
+	return name
+}
+
+// findFlag returns the FlagInfo with arg as one of its spellings.
+func findFlag(arg string) (FlagInfo, bool) {
+	for _, info := range flagInfos {
+		for _, flag := range info.Flags {
+			if flag == arg {
+				return info, true
+			}
+		}
+	}
+
+	return FlagInfo{}, false
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked means another instance holds the --lock-file.
+var errLocked = errors.New("held by another instance")
+
+// acquireLock fails, as there is no flock to take.
+func acquireLock(file string) (*os.File, error) {
+	return nil, errors.New("flag --lock-file is only supported on Unix")
+}
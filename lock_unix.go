@@ -0,0 +1,56 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// errLocked means another instance holds the --lock-file.
+var errLocked = errors.New("held by another instance")
+
+// acquireLock
+//
+//  Take an exclusive flock on file, creating it if missing, and record our pid
+//  in it.  Fails with errLocked, rather than waiting, if another instance holds
+//  the lock.  The lock lasts until the file is closed or we exit, and is not
+//  inherited by the app.
+//
+func acquireLock(file string) (*os.File, error) {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lock file: %v", err)
+	}
+
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("lock file (%s) is %w", file, errLocked)
+		}
+
+		return nil, fmt.Errorf("cannot lock file (%s): %v", file, err)
+	}
+
+	// the pid is only a hint for people; the flock is what counts.
+	if f.Truncate(0) == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return f, nil
+}
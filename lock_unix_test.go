@@ -0,0 +1,43 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestLockFile(t *testing.T) {
+	lock := filepath.Join(t.TempDir(), "app.lock")
+
+	first := startMain(t, "--lock-file", lock, "--", "/bin/sh", "-c", "echo ready; exec sleep 5")
+
+	_, stderr, code := runMain(t, "", "--lock-file", lock, "--", "/bin/true")
+	if code != int(AlreadyRunning) || !strings.Contains(stderr, "lock file ("+lock+") is") {
+		t.Errorf("second instance: got code %d, stderr %q; want %d", code, stderr, AlreadyRunning)
+	}
+
+	first.signal(syscall.SIGTERM)
+
+	if _, stderr, code := first.wait(); code != int(OK) {
+		t.Errorf("first instance: got code %d, stderr %q; want 0", code, stderr)
+	}
+
+	// released once the first instance exits.
+	if _, stderr, code = runMain(t, "", "--lock-file", lock, "--", "/bin/true"); code != int(OK) {
+		t.Errorf("third instance: got code %d, stderr %q; want 0", code, stderr)
+	}
+}
@@ -10,82 +10,363 @@
  *  http://www.wtfpl.net/ for more details.
  *
  *
- * Usage:     docker-run-app [-h] [--init-log FILE] [--] COMMAND
+ * Usage:     docker-run-app [-hV] [--allow-no-command] [--chdir DIR]
+ *                           [--chdir-create] [--chdir-mode MODE] [--chroot DIR]
+ *                           [--clear-env] [--clear-env-strict]
+ *                           [--colorize-child-levels] [--command-from-stdin]
+ *                           [--control-socket PATH] [--count-output]
+ *                           [--cwd-from-command] [--deadline DURATION]
+ *                           [--drain-message TEXT] [--drain-socket PATH]
+ *                           [--drain-timeout DURATION] [--drop-capabilities LIST]
+ *                           [--error-format FORMAT] [--exec-delay DURATION]
+ *                           [--expand-args] [--expand-strict] [--fail-fast]
+ *                           [--fail-on-stderr REGEX] [--forward-all-signals]
+ *                           [--heartbeat-interval DURATION] [--help-json]
+ *                           [--hold-open-on-exit] [--ignore-shutdown-exit-code]
+ *                           [--ignore-sigpipe] [--init-log FILE]
+ *                           [--init-log-dir-mode MODE] [--init-log-mkdir]
+ *                           [--json-config FILE] [--keep-capabilities LIST]
+ *                           [--kill-exit-code CODE] [--lock-file FILE]
+ *                           [--log-invocation] [--max-line BYTES]
+ *                           [--max-open-files N] [--merge-stderr]
+ *                           [--min-runtime DURATION] [--no-auto-env] [--no-color]
+ *                           [--no-force-kill] [--no-new-privileges]
+ *                           [--no-search-path] [--pass-fd N] [--post-start CMD]
+ *                           [--post-start-required] [--post-start-signal SIG]
+ *                           [--post-stop CMD] [--post-stop-timeout DURATION]
+ *                           [--print-config] [--report-usage] [--sd-notify]
+ *                           [--seccomp-profile FILE] [--shutdown-budget DURATION]
+ *                           [--signal-buffer N] [--signal-map LIST]
+ *                           [--start-delay DURATION] [--start-retries N]
+ *                           [--start-retry-delay DURATION] [--stderr-level LEVEL]
+ *                           [--stop-on-stdin-close] [--stop-pidfile FILE]
+ *                           [--stop-signal-env NAME] [--stop-signals LIST]
+ *                           [--syslog FACILITY] [--syslog-include-app]
+ *                           [--syslog-tag TAG] [--tag-streams]
+ *                           [--tolerate-escalation] [--trace-args] [--trace-fd N]
+ *                           [--trap-all] [--validate-json-stdout]
+ *                           [--watch-action ACTION] [--watch-file FILE]
+ *                           [--watch-signal SIG] [--wrap WRAPPER] [--] COMMAND
  *
- *   COMMAND         - app and args to execute. app requires full path.
- *   --              - args after this flag are reserved for COMMAND.
- *   -h, --help      - print this help message.
- *   --init-log FILE - write docker-run-app output to FILE.
- *   -V, --version   - print version info.
+ *   COMMAND                       - app and args to execute. app is looked up in PATH if it has no slash.
+ *   --                            - args after this flag are reserved for COMMAND.
+ *   --allow-no-command            - if COMMAND is not given, reap orphaned processes until SIGINT or SIGTERM.
+ *   --chdir DIR                   - run app from DIR.
+ *   --chdir-create                - create DIR of --chdir if it is missing.
+ *   --chdir-mode MODE             - create DIR with octal MODE, less umask. (default: 0755)
+ *   --chroot DIR                  - run app with DIR as its root directory. COMMAND is a path inside DIR. (Unix)
+ *   --clear-env                   - start app with only PATH and HOME from our environment.
+ *   --clear-env-strict            - start app with an empty environment.
+ *   --colorize-child-levels       - on a terminal, color app's output lines by the level they start with (e.g. ERROR).
+ *   --command-from-stdin          - if COMMAND is not given, read it from the first line of stdin.
+ *   --control-socket PATH         - accept commands (signal NAME, status) on unix socket PATH.
+ *   --count-output                - count bytes and lines of app's stdout and stderr, and log them on exit.
+ *   --cwd-from-command            - run app from the directory of COMMAND, if it is a path.
+ *   --deadline DURATION           - stop app, and exit with code 73, once DURATION has passed since we started.
+ *   --drain-message TEXT          - line sent to drain socket. (default: drain)
+ *   --drain-socket PATH           - on shutdown, drain app through unix socket PATH first.
+ *   --drain-timeout DURATION      - wait DURATION for app to drain. (default: 10s)
+ *   --drop-capabilities LIST      - drop LIST of capabilities (e.g. CAP_NET_RAW,SYS_ADMIN) from app. (Linux)
+ *   --error-format FORMAT         - report fatal errors as text or json. (default: text)
+ *   --exec-delay DURATION         - same as --start-delay.
+ *   --expand-args                 - expand $VAR and ${VAR} in COMMAND and its args.
+ *   --expand-strict               - with --expand-args, fail if a variable is not set.
+ *   --fail-fast                   - check that COMMAND exists and is executable before anything else.
+ *   --fail-on-stderr REGEX        - stop app if a line it writes to stderr matches REGEX.
+ *   --forward-all-signals         - forward every signal to app. SIGINT and SIGTERM stop app.
+ *   --heartbeat-interval DURATION - log that app is still running every DURATION.
+ *   -h, --help                    - print this help message.
+ *   --help-json                   - print flags as JSON.
+ *   --hold-open-on-exit           - after app exits, keep running until SIGTERM or SIGINT.
+ *   --ignore-shutdown-exit-code   - exit 0 even if app exits with an error once signaled to stop.
+ *   --ignore-sigpipe              - keep app alive once no one reads our output: ignore SIGPIPE, and discard app's output.
+ *   --init-log FILE               - write docker-run-app output to FILE (e.g. app-{date}-{pid}.log, stdout, syslog:local). (repeatable)
+ *   --init-log-dir-mode MODE      - create log directories with octal MODE, less umask. (default: 0755)
+ *   --init-log-mkdir              - create missing directories of --init-log files.
+ *   --json-config FILE            - read options, and COMMAND, from JSON object in FILE. flags win.
+ *   --keep-capabilities LIST      - drop every capability but LIST from app. (Linux)
+ *   --kill-exit-code CODE         - exit with CODE, not 65, if app had to be killed once signaled to stop.
+ *   --lock-file FILE              - take an exclusive lock on FILE, or exit if another instance holds it. (Unix)
+ *   --log-invocation              - log COMMAND's path, argv, directory and environment, with secrets masked, and the options given.
+ *   --max-line BYTES              - split app's output lines longer than BYTES when handling it by line. (default: 65536)
+ *   --max-open-files N            - limit app to N open files (RLIMIT_NOFILE). (Linux)
+ *   --merge-stderr                - write app's stderr to our stdout, interleaved with its stdout.
+ *   --min-runtime DURATION        - warn if app exits on its own, even cleanly, within DURATION of starting.
+ *   --no-auto-env                 - do not set DRA_VERSION, DRA_COMMAND and DRA_STARTED_AT for app.
+ *   --no-color                    - do not color docker-run-app's log on a terminal.
+ *   --no-force-kill               - never kill app; leave it to docker if it ignores stop signals.
+ *   --no-new-privileges           - prevent app from gaining privileges (e.g. setuid).
+ *   --no-search-path              - do not look up COMMAND in PATH; a bare name is then a file in the working directory.
+ *   --pass-fd N                   - pass our open file descriptor N to app as 3, 4, ... in order, and set LISTEN_FDS. (repeatable)
+ *   --post-start CMD              - run CMD with /bin/sh -c once app has started.
+ *   --post-start-required         - stop app if the --post-start CMD fails.
+ *   --post-start-signal SIG       - send SIG (e.g. SIGCONT) to app once it starts.
+ *   --post-stop CMD               - run CMD with /bin/sh -c after a signal has stopped app.
+ *   --post-stop-timeout DURATION  - kill the --post-stop CMD after DURATION. (default: 10s)
+ *   --print-config                - log the options in effect, after --json-config and defaults, before starting app.
+ *   --report-usage                - log CPU time and max RSS of app and docker-run-app on exit.
+ *   --sd-notify                   - relay app's sd_notify READY/WATCHDOG messages to NOTIFY_SOCKET. (Linux)
+ *   --seccomp-profile FILE        - apply seccomp BPF filter in FILE to app. (Linux)
+ *   --shutdown-budget DURATION    - fit all of shutdown in DURATION, then kill app.
+ *   --signal-buffer N             - queue up to N received signals while busy; more are dropped. (default: 1)
+ *   --signal-map LIST             - send app signal TO for each signal FROM we receive, from LIST of FROM=TO (e.g. SIGINT=SIGTERM,SIGTERM=SIGQUIT).
+ *   --start-delay DURATION        - wait DURATION (e.g. 1.5s) before starting app.
+ *   --start-retries N             - retry starting app N times if its file is missing or busy.
+ *   --start-retry-delay DURATION  - wait DURATION between start retries. (default: 1s)
+ *   --stderr-level LEVEL          - with --tag-streams, give app's stderr lines LEVEL (e.g. warn, error).
+ *   --stop-on-stdin-close         - forward stdin to app, and stop app if it runs 2s after stdin closes.
+ *   --stop-pidfile FILE           - on shutdown, also stop the process whose pid is in FILE.
+ *   --stop-signal-env NAME        - stop app with the signal in variable NAME (e.g. SIGQUIT) first, if set.
+ *   --stop-signals LIST           - stop app with LIST of SIG[:TIMEOUT] (e.g. SIGTERM:10s,SIGKILL:0).
+ *   --syslog FACILITY             - also log to the local syslog in FACILITY (e.g. daemon, local0). (Linux)
+ *   --syslog-include-app          - with --syslog, also send app's output to syslog, stderr lines as errors.
+ *   --syslog-tag TAG              - tag our syslog messages with TAG. (default: docker-run-app)
+ *   --tag-streams                 - write app's output as JSON lines tagged with their stream.
+ *   --tolerate-escalation         - exit 0 if app stops only after a later stop signal, or a kill.
+ *   --trace-args                  - log COMMAND's path and each of its args, quoted, before starting it.
+ *   --trace-fd N                  - write how long each startup and shutdown phase took to file descriptor N, as JSON lines.
+ *   --trap-all                    - forward every signal to app. only SIGTERM stops app.
+ *   --validate-json-stdout        - warn about each line app writes to stdout that is not JSON.
+ *   -V, --version                 - print version info.
+ *   --watch-action ACTION         - what to do when --watch-file changes: reload-signal. (default: reload-signal)
+ *   --watch-file FILE             - watch FILE, e.g. a mounted config, and act when it changes.
+ *   --watch-signal SIG            - with --watch-action reload-signal, send SIG to app. (default: SIGHUP)
+ *   --wrap WRAPPER                - run COMMAND as: WRAPPER -- COMMAND (e.g. "strace -f").
+ *
+ * Exit codes:
+ *
+ *   0                             - app exited, or was stopped, cleanly.
+ *   1                             - app exited with an error.
+ *   64                            - cannot start app.
+ *   65                            - app ignored stop signals and was killed.
+ *   66                            - missing argument.
+ *   67                            - stop signals were insufficient to stop app.
+ *   68                            - command not found or not executable.
+ *   69                            - bad flag.
+ *   70                            - app wrote --fail-on-stderr pattern.
+ *   71                            - required post-start hook failed.
+ *   72                            - another instance holds --lock-file.
+ *   73                            - --deadline passed.
  */
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 )
 
 var (
 	VERSION    string
 	BUILD_DATE string
+
+	// --error-format, known before any other flag is parsed.
+	errorFormat string
+)
+
+const (
+	SIG_TIMEOUT       = time.Second * 2
+	POST_START_DELAY  = time.Millisecond * 100
+	DRAIN_TIMEOUT     = time.Second * 10
+	WAIT_DELAY        = time.Second * 2
+	START_RETRY_DELAY = time.Second
+	POST_STOP_TIMEOUT = time.Second * 10
+	STDIN_CLOSE_GRACE = time.Second * 2
 )
 
+// exit codes.  our own failures use 64 and up, like sysexits.h, so they stand
+// apart from the app's.  never renumber them; scripts depend on them.
 const (
-	SIG_TIMEOUT = time.Second * 2
+	OK                  AppError = 0
+	AppStoppedWithError AppError = 1
 )
 
 const (
-	OK AppError = iota
-	AppStoppedWithError
-	CannotStartApp
+	CannotStartApp AppError = iota + 64
 	FailedToKillApp
 	MissingArgument
 	InsufficientSignalError
 	InvalidCommand
 	BadFlag
+	StderrMatched
+	PostStartFailed
+	AlreadyRunning
+	DeadlineExceeded
 )
 
+// exitCodes lists every exit code in the order usage() documents them.
+var exitCodes = []AppError{
+	OK,
+	AppStoppedWithError,
+	CannotStartApp,
+	FailedToKillApp,
+	MissingArgument,
+	InsufficientSignalError,
+	InvalidCommand,
+	BadFlag,
+	StderrMatched,
+	PostStartFailed,
+	AlreadyRunning,
+	DeadlineExceeded,
+}
+
 const (
 	FlagFound FlagError = iota
 	FlagNotFound
 	FlagHasTooFewParams
+	FlagParamIsFlag
 )
 
 type AppError int
 type FlagError int
 type ParamList []string
 
+// StopStep is one signal of the shutdown escalation, and how long to wait
+// for the app to exit after sending it.
+type StopStep struct {
+	Signal  os.Signal
+	Timeout time.Duration
+}
+
+// Stoppable is the part of *os.Process that stopProcess needs.
+type Stoppable interface {
+	Signal(sig os.Signal) error
+	Kill() error
+}
+
 func main() {
 	var (
 		args    []string
 		err     AppError = OK
-		file    *os.File
-		fileErr error
+		closers []io.Closer
+		logs    []io.Writer
 		options map[string]string
 	)
 
-	options, args = parseFlags(os.Args[1:])
+	log.SetOutput(sharedStderr)
+
+	// are we the exec helper for an app that needs restricting?
+	if os.Getenv(preExecEnv) != "" {
+		runPreExec()
+	}
+
+	// @FILE arguments first, so their flags are parsed like any other.
+	args, expandErr := expandResponseFiles(os.Args[1:])
+	if expandErr != nil {
+		badFlag("%v", expandErr)
+	}
+
+	options, args = parseFlags(args)
+
+	if options["trace-fd"] != "" {
+		fd, _ := strconv.Atoi(options["trace-fd"])
+
+		var traceErr error
+		if tracer, traceErr = openTracer(fd); traceErr != nil {
+			badFlag("flag --trace-fd cannot trace (%v)", traceErr)
+		}
+
+		closers = append(closers, tracer)
+		tracer.span("flag-parse", launched)
+	}
+
+	// log to every destination we can open.  stderr if none.
+	for _, name := range optionList(options, "init-log") {
+		switch {
+		case name == "stdout":
+			logs = append(logs, os.Stdout)
+		case name == "stderr":
+			logs = append(logs, sharedStderr)
+		case strings.HasPrefix(name, "syslog:") || strings.HasPrefix(name, "syslog+"):
+			if writer, syslogErr := openSyslog(name, SYSLOG_FACILITY, optionOr(options, "syslog-tag", SYSLOG_TAG)); syslogErr != nil {
+				log.Printf("Cannot open syslog (%s): %v", name, syslogErr)
+			} else {
+				closers = append(closers, writer)
+				logs = append(logs, writer)
+			}
+		default:
+			name = expandLogPath(name, time.Now(), os.Getpid())
+
+			if file, fileErr := openLogFile(name, options); fileErr != nil {
+				log.Printf("Cannot open log file (%s): %v", name, fileErr)
+			} else {
+				closers = append(closers, file)
+				logs = append(logs, file)
+			}
+		}
+	}
 
-	if options["init-log"] != "" {
-		if file, fileErr = os.OpenFile(options["init-log"], os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664); fileErr != nil {
-			log.Printf("Cannot open log file (%s).  Using stderr.", options["init-log"])
+	// --syslog is one more destination, or stderr if syslog is not there.
+	if options["syslog"] != "" {
+		if writer, syslogErr := openSyslog("syslog:local", options["syslog"], optionOr(options, "syslog-tag", SYSLOG_TAG)); syslogErr != nil {
+			log.Printf("Warning: cannot open syslog (%v). Using stderr.", syslogErr)
+			logs = append(logs, sharedStderr)
 		} else {
-			log.SetOutput(file)
+			closers = append(closers, writer)
+			logs = append(logs, writer)
+		}
+	}
+
+	if len(logs) > 0 {
+		log.SetOutput(io.MultiWriter(logs...))
+	} else {
+		// color only for people watching, never in files or pipes.
+		if options["no-color"] == "" && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stderr) {
+			log.SetOutput(colorWriter{sharedStderr})
+		}
+
+		if options["init-log"] != "" {
+			log.Println("Using stderr for log.")
+		}
+	}
+
+	// no COMMAND?  read one from stdin.
+	if len(args) == 0 && options["command-from-stdin"] != "" {
+		var readErr error
+
+		if args, readErr = readCommand(os.Stdin); readErr != nil {
+			reportError(BadFlag, fmt.Sprintf("flag --command-from-stdin cannot read command (%v)", readErr))
+			os.Exit(int(BadFlag))
 		}
 	}
 
+	// reaping for --allow-no-command, we are the init the warning asks for.
+	if warning := pid1Warning(os.Getpid()); warning != "" && (len(args) > 0 || options["allow-no-command"] == "") {
+		log.Println(warning)
+	}
+
+	if options["print-config"] != "" {
+		printConfig(options, args)
+	}
+
 	// has command?
-	if len(args) == 0 {
-		usage()
-		log.Println("missing <command>. ")
+	if len(args) == 0 && options["allow-no-command"] != "" {
+		err = superviseOnly()
+	} else if len(args) == 0 {
+		if errorFormat != "json" {
+			usage()
+		}
+		reportError(MissingArgument, "missing <command>")
 		err = MissingArgument
 	} else {
 		cmd := args[0]
@@ -96,11 +377,123 @@ func main() {
 			args = nil
 		}
 
-		err = runCommand(exec.Command(cmd, args...))
+		var setupErr error
+
+		clearing := options["clear-env"] != "" || options["clear-env-strict"] != ""
+
+		env := os.Environ()
+		if clearing {
+			env = clearEnv(env, options["clear-env-strict"] != "")
+		}
+
+		// against the environment the app will get.
+		if options["expand-args"] != "" {
+			var argv []string
+
+			if argv, setupErr = expandArgs(append([]string{cmd}, args...), env, options["expand-strict"] != ""); setupErr == nil {
+				cmd, args = argv[0], argv[1:]
+			}
+		}
+
+		// under --chroot, the PATH lookup would search our root, not the app's.
+		searchPath := options["no-search-path"] == "" && options["chroot"] == ""
+
+		// DRA_COMMAND names the app, not the wrapper.
+		appPath := ""
+
+		if options["wrap"] != "" {
+			appPath = lookCommand(cmd, searchPath)
+			cmd, args = wrapCommand(options["wrap"], cmd, args)
+			// the wrapper is a host tool, so look it up even with --no-search-path.
+			searchPath = options["chroot"] == ""
+		}
+
+		// canceled only if our own shutdown stalls.  see shutdownApp.
+		ctx, cancel := context.WithCancel(context.Background())
+		command := newCommand(ctx, cmd, args, searchPath)
+
+		if appPath == "" {
+			appPath = command.Path
+		}
+
+		if clearing {
+			command.Env = env
+		}
+
+		if setupErr == nil {
+			setupErr = setupDir(command, cmd, options)
+		}
+
+		// tell the app about its run.  DRA_STARTED_AT is added as it starts.
+		if setupErr == nil && options["no-auto-env"] == "" {
+			command.Env = append(env, "DRA_VERSION="+VERSION, "DRA_COMMAND="+appPath)
+		}
+
+		if setupErr == nil && options["trace-args"] != "" {
+			traceArgs(command)
+		}
+
+		if setupErr == nil && options["log-invocation"] != "" {
+			logInvocation(command, options)
+		}
+
+		// before the exec helper or chroot hide the app's path.
+		if setupErr == nil && options["fail-fast"] != "" {
+			setupErr = checkCommand(command, options["chroot"])
+		}
+
+		if setupErr == nil && options["pass-fd"] != "" {
+			setupErr = passFiles(command, optionList(options, "pass-fd"))
+		}
+
+		if setupErr == nil {
+			setupErr = setupPreExec(command, options)
+		}
+
+		if setupErr == nil && options["chroot"] != "" {
+			setupErr = setupChroot(command, options["chroot"])
+		}
+
+		var lock *os.File
+
+		if setupErr == nil && options["lock-file"] != "" {
+			lock, setupErr = acquireLock(options["lock-file"])
+		}
+
+		var control net.Listener
+
+		if setupErr == nil && options["control-socket"] != "" {
+			control, setupErr = listenControl(options["control-socket"])
+		}
+
+		if errors.Is(setupErr, errLocked) {
+			reportError(AlreadyRunning, setupErr.Error())
+			err = AlreadyRunning
+		} else if errors.Is(setupErr, errBadCommand) {
+			reportError(InvalidCommand, setupErr.Error())
+			err = InvalidCommand
+		} else if setupErr != nil {
+			reportError(BadFlag, setupErr.Error())
+			err = BadFlag
+		} else {
+			err = runCommand(ctx, cancel, command, control, options)
+		}
+
+		// removes the socket too.
+		if control != nil {
+			control.Close()
+		}
+
+		// exiting releases the lock anyway.  closing makes it explicit.
+		if lock != nil {
+			lock.Close()
+		}
+
+		cancel()
 	}
 
-	if file != nil {
-		file.Close()
+	for _, closer := range closers {
+		closer.Close()
 	}
 
 	os.Exit(int(err))
@@ -138,8 +531,8 @@ func eatFlag(args []string, flags []string, paramCount int) (params ParamList, r
 ArgLoop:
 	for a, b = 0, 0; a < len(args); a++ {
 		if "--" == args[a] {
-			// stop processing flags
-			a++
+			// stop processing flags.  keep "--" in remaining, so later
+			// calls stop here too.  parseFlags removes it.
 			break ArgLoop
 		} else if hasFlag(args[a]) {
 			a++
@@ -153,13 +546,14 @@ ArgLoop:
 
 			params = make(ParamList, paramCount)
 
-			for c := 0; c < availCount; c++ {
+			for c := 0; c < paramCount; c++ {
 				// only eat params, don't eat potential flags
 				if !strings.HasPrefix(args[a], "-") {
 					params[c] = args[a]
 					a++
 				} else {
-					params, remaining, err = ParamList{}, args, FlagHasTooFewParams
+					// return the flag in the param's place, to report it.
+					params, remaining, err = ParamList{args[a]}, args, FlagParamIsFlag
 					return
 				}
 			}
@@ -180,171 +574,2473 @@ ArgLoop:
 		b++
 	}
 
+	remaining = remaining[:b]
+
 	return
 }
 
-func envOr(name string, def string) string {
-	if val := os.Getenv(name); val != "" {
-		return val
+// newCommand
+//
+//  Build the app's command, killed if ctx is canceled.  Without searchPath,
+//  name is used as given, so a bare name resolves against the working
+//  directory rather than PATH.
+//
+func newCommand(ctx context.Context, name string, args []string, searchPath bool) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+
+	if searchPath {
+		return cmd
 	}
-	return def
-}
 
-func parseFlags(args []string) (options map[string]string, remaining []string) {
-	var (
-		flagErr FlagError
-		params  ParamList
-	)
+	if warning := commandPathWarning(name, searchPath); warning != "" {
+		log.Println(warning)
+	}
 
-	remaining = args
+	// undo the PATH lookup.
+	cmd.Path, cmd.Err = name, nil
 
-	// VERSION. eat flag. exit if found.
-	if _, remaining, flagErr = eatFlag(remaining, []string{"-V", "--version"}, 0); flagErr == FlagFound {
-		version()
-		os.Exit(int(OK))
+	return cmd
+}
+
+// setupDir
+//
+//  Choose the app's working directory from --cwd-from-command or --chdir.
+//  With --chdir-create, a missing --chdir directory is created with
+//  --chdir-mode, less our umask.
+//
+func setupDir(cmd *exec.Cmd, name string, options map[string]string) error {
+	if options["cwd-from-command"] != "" {
+		useCommandDir(cmd, name)
+		return nil
 	}
 
-	// HELP. eat flag. exit if found.
-	if _, remaining, flagErr = eatFlag(remaining, []string{"-h", "--help"}, 0); flagErr == FlagFound {
-		usage()
-		os.Exit(int(OK))
+	dir := options["chdir"]
+	if dir == "" {
+		return nil
 	}
 
-	// we now have potential flags to return
+	// under --chroot, dir is inside the new root, whatever our own directory.
+	if options["chroot"] != "" {
+		dir = filepath.Join("/", dir)
+	}
 
-	options = make(map[string]string)
+	hostDir := filepath.Join(options["chroot"], dir)
 
-	// INIT LOG. eat flag, 1 param. exit if error.
-	if params, remaining, flagErr = eatFlag(remaining, []string{"--init-log"}, 1); flagErr == FlagHasTooFewParams {
-		log.Println("Error: flag --init-log is missing an argument.")
-		usage()
-		os.Exit(int(BadFlag))
-	} else {
-		options["init-log"] = params.getOr(0, "")
+	if options["chdir-create"] != "" {
+		// checked by parseFlags.
+		mode, _ := strconv.ParseUint(optionOr(options, "chdir-mode", "0755"), 8, 32)
+
+		if err := os.MkdirAll(hostDir, os.FileMode(mode)); err != nil {
+			return fmt.Errorf("cannot create directory: %v", err)
+		}
 	}
 
-	return
+	if info, err := os.Stat(hostDir); err != nil {
+		return fmt.Errorf("cannot use directory: %v", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("not a directory (%s)", dir)
+	}
+
+	cmd.Dir = dir
+
+	return nil
 }
 
-func runCommand(cmd *exec.Cmd) AppError {
-	sigs := make(chan os.Signal, 1)
-	done := make(chan error, 1)
+// useCommandDir
+//
+//  Run the app from the directory holding its command.  A bare name has no
+//  directory of its own, so it is left alone.  The path is made absolute
+//  first, since exec resolves a relative path against the new directory.
+//
+func useCommandDir(cmd *exec.Cmd, name string) {
+	if !strings.Contains(name, "/") {
+		return
+	}
 
-	// listen for signals from docker daemon
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		log.Printf("Cannot resolve command path (%s): %v", name, err)
+		return
+	}
 
-	// run the app from goroutine, so we can monitor signals and app
-	// termination
-	go func() {
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			log.Println("Cannot open pipe to app's stdout: ", err)
-		}
+	cmd.Path = abs
+	cmd.Dir = filepath.Dir(abs)
+}
 
-		stderr, err := cmd.StderrPipe()
-		if err != nil {
-			log.Println("Cannot open pipe to app's stderr: ", err)
-		}
+// wrapCommand
+//
+//  Run name under the --wrap command, e.g. "strace -f", so the app's command
+//  becomes: strace -f -- name args...  Signals go to the wrapper, which must
+//  relay them to the app.
+//
+func wrapCommand(wrapper string, name string, args []string) (string, []string) {
+	words := strings.Fields(wrapper)
 
-		err = cmd.Start()
-		if err != nil {
-			log.Fatal(err)
-			done <- err
-			return
+	wrapped := append([]string{}, words[1:]...)
+	wrapped = append(wrapped, "--", name)
+	wrapped = append(wrapped, args...)
+
+	return words[0], wrapped
+}
+
+// lookCommand
+//
+//  Return the path name runs from: its PATH match if searchPath and there is
+//  one, else name as given.
+//
+func lookCommand(name string, searchPath bool) string {
+	if searchPath {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
 		}
+	}
 
-		log.Println("App started.")
+	return name
+}
 
-		// redirect apps's stdout/stderr to our stdout/stderr, respectively
-		go io.Copy(os.Stdout, stdout)
-		go io.Copy(os.Stderr, stderr)
+// readCommand
+//
+//  Read one line from r and split it into words as a shell would, minus
+//  expansions.  Bytes are read one at a time, so whatever follows the line is
+//  left on r for the app.  An empty line, or none, gives no words.
+//
+func readCommand(r io.Reader) ([]string, error) {
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
 
-		err = cmd.Wait()
-		done <- err
-	}()
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
 
-	// monitor termination of app or signals from docker
-	select {
-	case err := <-done:
-		if err == nil {
-			log.Println("App stopped.")
-			return OK
-		} else {
-			log.Printf("App stopped with error (%v)", err)
-			return AppStoppedWithError
+			line = append(line, b[0])
 		}
-	case sig := <-sigs:
-		log.Printf("Received signal (%v).", sig)
 
-		sigSuccess, err := stopProcess(cmd.Process, sig, syscall.SIGTERM, syscall.SIGHUP)
-
-		if err != OK {
-			log.Println(err)
-			return err
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
 		}
+	}
 
-		log.Printf("App stopped with signal (%v).\n", sigSuccess)
+	return splitWords(strings.TrimSuffix(string(line), "\r"))
+}
 
-		// did app stop with the expected signal?
-		switch sigSuccess {
-		case sig:
-			return OK
-		case syscall.SIGINT:
-			return OK
+// splitWords
+//
+//  Split line into words at unquoted whitespace.  'single quotes' keep their
+//  text as is.  Within "double quotes", and outside quotes, a backslash keeps
+//  the next character as is.
+//
+func splitWords(line string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+		escape bool
+	)
+
+	for _, c := range line {
+		switch {
+		case escape:
+			word.WriteRune(c)
+			escape = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\':
+			escape, inWord = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case unicode.IsSpace(c):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
 		default:
-			return InsufficientSignalError
+			word.WriteRune(c)
+			inWord = true
 		}
 	}
 
-	return OK
+	if escape {
+		return nil, errors.New("line ends with a backslash")
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
 }
 
-/** stopProcess
- *
- * given a process and an ordered list of signals, send the first signal and
- * delay.  if the process did not stop, then repeat with subsequent signals
- * until the app responds, or we run out of signals.
- */
-func stopProcess(p *os.Process, sigs ...os.Signal) (os.Signal, AppError) {
-	if len(sigs) == 0 {
-		if err := p.Kill(); err != nil {
-			log.Fatal("Failed to kill app: ", err)
+// errBadCommand means --fail-fast found COMMAND missing or not executable.
+var errBadCommand = errors.New("cannot run command")
+
+// checkCommand
+//
+//  Check, without starting it, that cmd's file exists and is executable, to
+//  fail with a precise reason.  A relative path is taken from cmd.Dir, and
+//  any path from inside chroot, as exec would.
+//
+func checkCommand(cmd *exec.Cmd, chroot string) error {
+	if cmd.Err != nil {
+		return fmt.Errorf("%w: %s not found in PATH", errBadCommand, cmd.Args[0])
+	}
+
+	file := cmd.Path
+	if chroot != "" {
+		file = filepath.Join(chroot, file)
+	} else if !filepath.IsAbs(file) && cmd.Dir != "" {
+		file = filepath.Join(cmd.Dir, file)
+	}
+
+	info, err := os.Stat(file)
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %s not found", errBadCommand, file)
+	case err != nil:
+		return fmt.Errorf("%w: %v", errBadCommand, err)
+	case info.IsDir():
+		return fmt.Errorf("%w: %s is a directory", errBadCommand, file)
+	case runtime.GOOS != "windows" && info.Mode()&0111 == 0:
+		return fmt.Errorf("%w: %s is not executable", errBadCommand, file)
+	}
+
+	return nil
+}
+
+// passFiles
+//
+//  Hand the open file descriptors fds to the app as 3, 4, and so on, in order,
+//  the way systemd socket activation does, and set LISTEN_FDS to their count.
+//  On Linux the exec helper sets LISTEN_PID, as only it knows the app's pid.
+//
+func passFiles(cmd *exec.Cmd, fds []string) error {
+	for _, value := range fds {
+		// checked by parseFlags.
+		fd, _ := strconv.Atoi(value)
+
+		file := os.NewFile(uintptr(fd), "fd "+value)
+		if _, err := file.Stat(); err != nil {
+			return fmt.Errorf("flag --pass-fd has a file descriptor (%d) that is not open", fd)
 		}
-		return nil, FailedToKillApp
+
+		// only as its new number.
+		keepFromApp(file)
+
+		cmd.ExtraFiles = append(cmd.ExtraFiles, file)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
 	}
+	cmd.Env = setEnv(env, "LISTEN_FDS", strconv.Itoa(len(fds)))
 
-	c := make(chan error, 1)
+	return nil
+}
 
-	go func() {
-		log.Printf("Attempting to stop app with signal (%v).", sigs[0])
-		c <- p.Signal(sigs[0])
-	}()
+// traceArgs
+//
+//  Log the path and each argv element of cmd, quoted, to show how the command
+//  line was split.
+//
+func traceArgs(cmd *exec.Cmd) {
+	log.Printf("Command path (%q).", cmd.Path)
 
-	select {
-	case err := <-c:
-		if err == nil {
-			return sigs[0], OK
-		} else {
-			return stopProcess(p, sigs[1:]...)
+	for i, arg := range cmd.Args {
+		log.Printf("Command argv[%d] (%q).", i, arg)
+	}
+}
+
+// secretEnvWords mark variables whose values logInvocation masks.
+var secretEnvWords = []string{"SECRET", "PASSWORD", "PASSWD", "TOKEN", "KEY", "CREDENTIAL", "PRIVATE"}
+
+// logInvocation
+//
+//  Log, as one record, what we are about to run for --log-invocation: cmd's
+//  path, argv, working directory and environment, our uid and gid, and the
+//  options given.  Values of variables that look secret (e.g. DB_PASSWORD) are
+//  masked.  With --error-format json the record is a JSON object on stderr.
+//
+func logInvocation(cmd *exec.Cmd, options map[string]string) {
+	dir := cmd.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	masked := make([]string, len(env))
+	for i, kv := range env {
+		masked[i] = maskSecretEnv(kv)
+	}
+
+	config, _ := effectiveConfig(options, nil)
+
+	invocation := struct {
+		Path    string                 `json:"path"`
+		Argv    []string               `json:"argv"`
+		Dir     string                 `json:"dir"`
+		Uid     int                    `json:"uid"`
+		Gid     int                    `json:"gid"`
+		Options map[string]interface{} `json:"options"`
+		Env     []string               `json:"env"`
+	}{cmd.Path, cmd.Args, dir, os.Getuid(), os.Getgid(), config, masked}
+
+	if errorFormat == "json" {
+		enc := json.NewEncoder(sharedStderr)
+		enc.SetEscapeHTML(false)
+		enc.Encode(struct {
+			Invocation interface{} `json:"invocation"`
+		}{invocation})
+		return
+	}
+
+	record, _ := json.Marshal(invocation)
+	log.Printf("Invocation (%s).", record)
+}
+
+// maskSecretEnv replaces the value of a NAME=VALUE pair with *** if NAME holds
+// one of secretEnvWords.
+func maskSecretEnv(kv string) string {
+	name, _, found := strings.Cut(kv, "=")
+	if !found {
+		return kv
+	}
+
+	upper := strings.ToUpper(name)
+
+	for _, word := range secretEnvWords {
+		if strings.Contains(upper, word) {
+			return name + "=***"
 		}
-	case _ = <-time.After(SIG_TIMEOUT):
-		return stopProcess(p, sigs[1:]...)
 	}
+
+	return kv
+}
+
+// commandPathWarning
+//
+//  Explain why a bare command name will likely fail to exec.  Returns "" when
+//  there is nothing to warn about.
+//
+func commandPathWarning(name string, searchPath bool) string {
+	if searchPath || strings.Contains(name, "/") {
+		return ""
+	}
+
+	return fmt.Sprintf("Warning: command (%s) is not a path and PATH is not searched. Use an absolute path (e.g. /usr/bin/%s).", name, name)
 }
 
-func usage() {
-	prog := path.Base(os.Args[0])
+// openLogFile
+//
+//  Open an --init-log file for appending.  With --init-log-mkdir, missing
+//  parent directories are created with --init-log-dir-mode, less our umask.
+//
+func openLogFile(name string, options map[string]string) (*os.File, error) {
+	dir := filepath.Dir(name)
 
-	fmt.Printf("Usage:     %s [-h] [--init-log FILE] [--] COMMAND\n", prog)
-	fmt.Println()
-	fmt.Println("Commands:")
-	fmt.Println()
-	fmt.Println("  COMMAND         - app and args to execute. app requires full path.")
-	fmt.Println("  --              - args after this flag are reserved for COMMAND.")
-	fmt.Println("  -h, --help      - print this help message.")
-	fmt.Printf("  --init-log FILE - write %s output to FILE.\n", prog)
-	fmt.Println("  -V, --version   - print version info.")
-	fmt.Println()
+	if options["init-log-mkdir"] != "" {
+		// checked by parseFlags.
+		mode, _ := strconv.ParseUint(optionOr(options, "init-log-dir-mode", "0755"), 8, 32)
+
+		if err := os.MkdirAll(dir, os.FileMode(mode)); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, dirErr := os.Stat(dir); errors.Is(dirErr, fs.ErrNotExist) {
+			return nil, fmt.Errorf("directory (%s) does not exist. Use --init-log-mkdir to create it", dir)
+		}
+	}
+
+	return file, err
+}
+
+// expandLogPath
+//
+//  Fill in the placeholders of an --init-log path, so each run can have its own
+//  file, e.g. /var/log/app-{date}-{pid}.log.  {date} is now as YYYY-MM-DD,
+//  {pid} is pid, and {hostname} is the container's hostname.
+//
+func expandLogPath(name string, now time.Time, pid int) string {
+	if !strings.Contains(name, "{") {
+		return name
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{pid}", strconv.Itoa(pid),
+		"{hostname}", hostname,
+	).Replace(name)
+}
+
+// pid1Warning
+//
+//  Explain the risk of running as init.  docker-run-app does not reap orphaned
+//  processes, so as PID 1 any the app leaves behind become zombies.  Returns ""
+//  for any other pid.
+//
+func pid1Warning(pid int) string {
+	if pid != 1 {
+		return ""
+	}
+
+	return "Warning: running as PID 1, but orphaned processes are not reaped and will become zombies. Run the container with --init (e.g. docker run --init) if the app spawns children."
+}
+
+func envOr(name string, def string) string {
+	if val := os.Getenv(name); val != "" {
+		return val
+	}
+	return def
+}
+
+// expandArgs
+//
+//  Expand $VAR and ${VAR} in each of args from env, like a shell would, but
+//  without word splitting.  An unset variable expands to "", or is an error if
+//  strict.
+//
+func expandArgs(args []string, env []string, strict bool) ([]string, error) {
+	var missing []string
+
+	vars := map[string]string{}
+
+	for _, entry := range env {
+		if i := strings.Index(entry, "="); i > 0 {
+			vars[entry[:i]] = entry[i+1:]
+		}
+	}
+
+	lookup := func(name string) string {
+		value, found := vars[name]
+		if !found {
+			missing = append(missing, name)
+		}
+		return value
+	}
+
+	expanded := make([]string, len(args))
+
+	for i, arg := range args {
+		expanded[i] = os.Expand(arg, lookup)
+	}
+
+	if strict && len(missing) > 0 {
+		return nil, fmt.Errorf("cannot expand args, variable (%s) is not set", missing[0])
+	}
+
+	return expanded, nil
+}
+
+// clearEnv
+//
+//  Return the minimal environment for --clear-env: only PATH and HOME from env,
+//  or nothing at all if strict.  Never nil, since a nil Cmd.Env inherits ours.
+//
+func clearEnv(env []string, strict bool) []string {
+	result := []string{}
+
+	if strict {
+		return result
+	}
+
+	for _, entry := range env {
+		if strings.HasPrefix(entry, "PATH=") || strings.HasPrefix(entry, "HOME=") {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// setEnv returns env with key set to value, replacing any previous value.
+func setEnv(env []string, key string, value string) []string {
+	result := make([]string, 0, len(env)+1)
+
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, key+"=") {
+			result = append(result, entry)
+		}
+	}
+
+	return append(result, key+"="+value)
+}
+
+func optionOr(options map[string]string, name string, def string) string {
+	if val := options[name]; val != "" {
+		return val
+	}
+	return def
+}
+
+func parseFlags(args []string) (options map[string]string, remaining []string) {
+	var (
+		command []string
+		flagErr FlagError
+	)
+
+	remaining = args
+
+	// VERSION. eat flag. exit if found.
+	if _, remaining, flagErr = eatFlag(remaining, []string{"-V", "--version"}, 0); flagErr == FlagFound {
+		version()
+		os.Exit(int(OK))
+	}
+
+	// HELP. eat flag. exit if found.
+	if _, remaining, flagErr = eatFlag(remaining, []string{"-h", "--help"}, 0); flagErr == FlagFound {
+		usage()
+		os.Exit(int(OK))
+	}
+
+	// HELP JSON. eat flag. exit if found.
+	if _, remaining, flagErr = eatFlag(remaining, []string{"--help-json"}, 0); flagErr == FlagFound {
+		usageJSON()
+		os.Exit(int(OK))
+	}
+
+	// we now have potential flags to return
+
+	options = make(map[string]string)
+
+	// ERROR FORMAT. eat flag, 1 param, before any other flag can fail.
+	remaining = eatOption(remaining, options, "error-format", []string{"--error-format"}, 1)
+
+	switch options["error-format"] {
+	case "", "text", "json":
+		errorFormat = options["error-format"]
+	default:
+		badFlag("flag --error-format has an invalid format (%s)", options["error-format"])
+	}
+
+	// OPTIONS. eat flags, 0 or 1 param. exit if error.
+	for _, info := range flagInfos {
+		switch info.Name {
+		case "error-format", "help", "help-json", "version":
+			// eaten above
+		default:
+			if info.repeatable() {
+				remaining = eatOptions(remaining, options, info.Name, info.Flags, info.paramCount())
+			} else {
+				remaining = eatOption(remaining, options, info.Name, info.Flags, info.paramCount())
+			}
+		}
+	}
+
+	// CONFIG. fill in options not given above. exit if error.
+	if options["json-config"] != "" {
+		var err error
+
+		if command, err = loadJSONConfig(options["json-config"], options); err != nil {
+			badFlag("flag --json-config has a bad config (%s): %v", options["json-config"], err)
+		}
+	}
+
+	// ALIASES. --exec-delay is --start-delay.
+	if options["start-delay"] == "" {
+		options["start-delay"] = options["exec-delay"]
+	}
+
+	// DURATIONS. validate. exit if error.
+	checkDuration(options, "deadline")
+	checkDuration(options, "drain-timeout")
+	checkDuration(options, "exec-delay")
+	checkDuration(options, "heartbeat-interval")
+	checkDuration(options, "min-runtime")
+	checkDuration(options, "post-stop-timeout")
+	checkDuration(options, "shutdown-budget")
+	checkDuration(options, "start-delay")
+	checkDuration(options, "start-retry-delay")
+
+	if d, _ := time.ParseDuration(options["heartbeat-interval"]); options["heartbeat-interval"] != "" && d == 0 {
+		badFlag("flag --heartbeat-interval must be more than 0")
+	}
+
+	// COUNTS. validate. exit if error.
+	checkCount(options, "kill-exit-code")
+	checkCount(options, "max-line")
+	checkCount(options, "max-open-files")
+	checkCount(options, "signal-buffer")
+	checkCount(options, "start-retries")
+	checkCount(options, "trace-fd")
+
+	if n, _ := strconv.Atoi(options["kill-exit-code"]); n > 255 {
+		badFlag("flag --kill-exit-code must be at most 255")
+	}
+
+	if n, _ := strconv.Atoi(options["max-line"]); options["max-line"] != "" && n < 1 {
+		badFlag("flag --max-line must be at least 1")
+	}
+
+	if n, _ := strconv.Atoi(options["signal-buffer"]); options["signal-buffer"] != "" && n < 1 {
+		badFlag("flag --signal-buffer must be at least 1")
+	}
+
+	// 0 to 2 are the app's stdio already.
+	passed := map[string]bool{}
+
+	for _, value := range optionList(options, "pass-fd") {
+		if n, err := strconv.Atoi(value); err != nil || n < 3 {
+			badFlag("flag --pass-fd has an invalid file descriptor (%s), must be at least 3", value)
+		}
+
+		if passed[value] {
+			badFlag("flag --pass-fd has a repeated file descriptor (%s)", value)
+		}
+		passed[value] = true
+	}
+
+	// stdin is for the app, or --command-from-stdin.
+	if n, _ := strconv.Atoi(options["trace-fd"]); options["trace-fd"] != "" && n < 1 {
+		badFlag("flag --trace-fd must be at least 1")
+	}
+
+	// SIGNALS. validate. exit if error.
+	checkSignal(options, "post-start-signal")
+	checkSignal(options, "watch-signal")
+
+	if name := options["stop-signal-env"]; name != "" && os.Getenv(name) != "" {
+		if _, err := parseSignal(os.Getenv(name)); err != nil {
+			badFlag("flag --stop-signal-env names a variable (%s) with an %v", name, err)
+		}
+	}
+
+	if options["stop-signals"] != "" {
+		steps, err := parseStopSignals(options["stop-signals"])
+		if err != nil {
+			badFlag("flag --stop-signals has an %v", err)
+		}
+
+		for _, step := range steps {
+			if step.Signal == syscall.SIGKILL && options["no-force-kill"] != "" {
+				badFlag("flag --stop-signals cannot send SIGKILL with --no-force-kill")
+			}
+		}
+	}
+
+	if _, err := parseSignalMap(options["signal-map"]); err != nil {
+		badFlag("flag --signal-map has an %v", err)
+	}
+
+	// WRAPPER. validate. exit if error.
+	if _, found := options["wrap"]; found && strings.TrimSpace(options["wrap"]) == "" {
+		badFlag("flag --wrap has no command")
+	}
+
+	if options["wrap"] != "" && options["cwd-from-command"] != "" {
+		badFlag("flags --wrap and --cwd-from-command cannot be used together")
+	}
+
+	// MODES. validate. exit if error.
+	checkMode(options, "chdir-mode")
+	checkMode(options, "init-log-dir-mode")
+
+	// FACILITIES. validate. exit if error.
+	if options["syslog"] != "" {
+		if err := checkSyslogFacility(options["syslog"]); err != nil {
+			badFlag("flag --syslog has an %v", err)
+		}
+	}
+
+	// DIRECTORIES. validate. exit if error.
+	if options["chroot"] != "" {
+		// the exec helper would look in our root, not the app's.
+		for _, name := range []string{"drop-capabilities", "keep-capabilities", "max-open-files", "no-new-privileges", "pass-fd", "seccomp-profile"} {
+			if options[name] != "" {
+				badFlag("flags --chroot and --%s cannot be used together", name)
+			}
+		}
+	}
+
+	if options["chdir"] != "" && options["cwd-from-command"] != "" {
+		badFlag("flags --chdir and --cwd-from-command cannot be used together")
+	}
+
+	if options["chdir"] == "" && (options["chdir-create"] != "" || options["chdir-mode"] != "") {
+		badFlag("flags --chdir-create and --chdir-mode need --chdir")
+	}
+
+	// LEVELS. validate. exit if error.
+	switch options["stderr-level"] {
+	case "", "debug", "info", "warn", "error":
+	default:
+		badFlag("flag --stderr-level has an invalid level (%s)", options["stderr-level"])
+	}
+
+	// ACTIONS. validate. exit if error.
+	switch options["watch-action"] {
+	case "", "reload-signal":
+	case "restart":
+		badFlag("flag --watch-action cannot restart; docker-run-app never restarts the app")
+	default:
+		badFlag("flag --watch-action has an invalid action (%s)", options["watch-action"])
+	}
+
+	if (options["watch-action"] != "" || options["watch-signal"] != "") && options["watch-file"] == "" {
+		badFlag("flags --watch-action and --watch-signal need --watch-file")
+	}
+
+	if options["kill-exit-code"] != "" && (options["tolerate-escalation"] != "" || options["no-force-kill"] != "") {
+		badFlag("flag --kill-exit-code cannot be used with --tolerate-escalation or --no-force-kill")
+	}
+
+	if options["post-stop-timeout"] != "" && options["post-stop"] == "" {
+		badFlag("flag --post-stop-timeout needs --post-stop")
+	}
+
+	if options["post-start-required"] != "" && options["post-start"] == "" {
+		badFlag("flag --post-start-required needs --post-start")
+	}
+
+	if options["expand-strict"] != "" && options["expand-args"] == "" {
+		badFlag("flag --expand-strict needs --expand-args")
+	}
+
+	if options["colorize-child-levels"] != "" && options["tag-streams"] != "" {
+		badFlag("flags --colorize-child-levels and --tag-streams cannot be used together")
+	}
+
+	if options["stderr-level"] != "" && options["tag-streams"] == "" {
+		badFlag("flag --stderr-level needs --tag-streams")
+	}
+
+	if options["init-log-dir-mode"] != "" && options["init-log-mkdir"] == "" {
+		badFlag("flag --init-log-dir-mode needs --init-log-mkdir")
+	}
+
+	if options["syslog-include-app"] != "" && options["syslog"] == "" {
+		badFlag("flag --syslog-include-app needs --syslog")
+	}
+
+	// PATTERNS. validate. exit if error.
+	if options["fail-on-stderr"] != "" {
+		if _, err := regexp.Compile(options["fail-on-stderr"]); err != nil {
+			badFlag("flag --fail-on-stderr has an invalid pattern (%v)", err)
+		}
+	}
+
+	// END OF FLAGS. drop "--". what follows is COMMAND.  a leading dash is a
+	// flag we do not know, not COMMAND.  "--" lets COMMAND start with one.
+	if len(remaining) > 0 && remaining[0] != "--" && strings.HasPrefix(remaining[0], "-") {
+		// only the first of a flag that is not repeatable was eaten.
+		if _, found := findFlag(remaining[0]); found {
+			badFlag("flag %s cannot be given more than once", remaining[0])
+		}
+
+		badFlag("unknown flag (%s)", remaining[0])
+	}
+
+	remaining = dropFlagTerminator(remaining)
+
+	if len(remaining) == 0 && command != nil {
+		remaining = command
+	}
+
+	return
+}
+
+// dropFlagTerminator
+//
+//  Remove the first "--" from args.  Everything after it belongs to COMMAND,
+//  even if it looks like one of our flags.
+//
+func dropFlagTerminator(args []string) []string {
+	for i := range args {
+		if args[i] == "--" {
+			return append(args[:i:i], args[i+1:]...)
+		}
+	}
+
+	return args
+}
+
+// badFlag
+//
+//  Report a bad flag and exit with BadFlag.
+//
+func badFlag(format string, args ...interface{}) {
+	reportError(BadFlag, fmt.Sprintf(format, args...))
+
+	if errorFormat != "json" {
+		usage()
+	}
+
+	os.Exit(int(BadFlag))
+}
+
+// reportError
+//
+//  Report a fatal error.  With --error-format json the error is written to
+//  stderr as one JSON object, e.g. {"code":7,"message":"..."}, for CI tools
+//  to parse.  Otherwise it is logged.
+//
+func reportError(code AppError, message string) {
+	if errorFormat != "json" {
+		log.Printf("Error: %s.", message)
+		return
+	}
+
+	enc := json.NewEncoder(sharedStderr)
+	enc.SetEscapeHTML(false)
+	enc.Encode(struct {
+		Code    AppError `json:"code"`
+		Message string   `json:"message"`
+	}{code, message})
+}
+
+// checkDuration
+//
+//  Exit with BadFlag if option name is set and is not a valid, non-negative
+//  duration (e.g. 1.5s, 2m).
+//
+func checkDuration(options map[string]string, name string) {
+	if options[name] == "" {
+		return
+	}
+
+	if d, err := time.ParseDuration(options[name]); err != nil || d < 0 {
+		badFlag("flag --%s has an invalid duration (%s)", name, options[name])
+	}
+}
+
+// checkCount
+//
+//  Exit with BadFlag if option name is set and is not a non-negative integer.
+//
+func checkCount(options map[string]string, name string) {
+	if options[name] == "" {
+		return
+	}
+
+	if n, err := strconv.Atoi(options[name]); err != nil || n < 0 {
+		badFlag("flag --%s has an invalid count (%s)", name, options[name])
+	}
+}
+
+// checkMode
+//
+//  Exit with BadFlag if option name is set and is not an octal file mode
+//  (e.g. 0755).
+//
+func checkMode(options map[string]string, name string) {
+	if options[name] == "" {
+		return
+	}
+
+	if _, err := strconv.ParseUint(options[name], 8, 32); err != nil || len(options[name]) > 4 {
+		badFlag("flag --%s has an invalid mode (%s)", name, options[name])
+	}
+}
+
+// checkSignal
+//
+//  Exit with BadFlag if option name is set and is not a known signal.
+//
+func checkSignal(options map[string]string, name string) {
+	if options[name] == "" {
+		return
+	}
+
+	if _, err := parseSignal(options[name]); err != nil {
+		badFlag("flag --%s has an %v", name, err)
+	}
+}
+
+// eatOption
+//
+//  Eat one flag and store its parameter in options under name.  Flags without
+//  parameters store "true".  Exit with BadFlag if the flag is missing a
+//  parameter.
+//
+func eatOption(args []string, options map[string]string, name string, flags []string, paramCount int) (remaining []string) {
+	var (
+		flagErr FlagError
+		params  ParamList
+	)
+
+	params, remaining, flagErr = eatFlag(args, flags, paramCount)
+
+	switch flagErr {
+	case FlagHasTooFewParams:
+		badFlag("flag %s needs a value", flags[len(flags)-1])
+	case FlagParamIsFlag:
+		badFlag("flag %s value cannot be a flag (%s)", flags[len(flags)-1], params.getOr(0, ""))
+	case FlagFound:
+		if paramCount == 0 {
+			options[name] = "true"
+		} else {
+			options[name] = params.getOr(0, "")
+		}
+	}
+
+	return
+}
+
+// eatOptions
+//
+//  Like eatOption, but eat every occurrence of the flag.  The parameters are
+//  kept in order, joined by NUL, which cannot appear in an argument.  Read them
+//  back with optionList.
+//
+func eatOptions(args []string, options map[string]string, name string, flags []string, paramCount int) (remaining []string) {
+	var values []string
+
+	remaining = args
+
+	for {
+		remaining = eatOption(remaining, options, name, flags, paramCount)
+
+		value, found := options[name]
+		if !found {
+			break
+		}
+
+		values = append(values, value)
+		delete(options, name)
+	}
+
+	if len(values) > 0 {
+		options[name] = strings.Join(values, "\x00")
+	}
+
+	return
+}
+
+// optionList returns every value of a repeatable option, in order.
+func optionList(options map[string]string, name string) []string {
+	if options[name] == "" {
+		return nil
+	}
+	return strings.Split(options[name], "\x00")
+}
+
+// startCommand starts the app.  Tests replace it to fail a start.
+var startCommand = (*exec.Cmd).Start
+
+func runCommand(ctx context.Context, cancel context.CancelFunc, cmd *exec.Cmd, control net.Listener, options map[string]string) (code AppError) {
+	// signals that arrive while the channel is full are dropped.
+	buffer, _ := strconv.Atoi(optionOr(options, "signal-buffer", "1"))
+
+	sigs := make(chan os.Signal, buffer)
+	exited := make(chan struct{})
+	forwardAll := options["trap-all"] != "" || options["forward-all-signals"] != ""
+
+	// listen for signals from docker daemon, and any --signal-map remaps.
+	if forwardAll {
+		signal.Notify(sigs)
+	} else {
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+		mapping, _ := parseSignalMap(options["signal-map"])
+		for from := range mapping {
+			signal.Notify(sigs, from)
+		}
+	}
+
+	// after Notify, which would undo it.  the app inherits SIG_IGN, so a
+	// broken pipe is a write error for it too, not death.
+	if options["ignore-sigpipe"] != "" {
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
+	// --deadline bounds the whole run.  deadline is closed once it passes.  a
+	// shutdown already under way is then cut short by killing the app, and
+	// whatever we were doing, we exit with DeadlineExceeded.
+	var (
+		deadline     chan struct{}
+		shuttingDown int32
+	)
+
+	if options["deadline"] != "" {
+		limit, _ := time.ParseDuration(options["deadline"])
+		deadline = make(chan struct{})
+
+		timer := time.AfterFunc(limit, func() {
+			log.Printf("Deadline passed (%v).", limit)
+			close(deadline)
+
+			if atomic.LoadInt32(&shuttingDown) != 0 {
+				log.Println("Killing app.")
+				cmd.Process.Kill()
+			}
+		})
+		defer timer.Stop()
+
+		defer func() {
+			select {
+			case <-deadline:
+				code = DeadlineExceeded
+			default:
+			}
+		}()
+	}
+
+	// closed once we stop watching the app: a shutdown began, or we return.
+	var (
+		stopping     = make(chan struct{})
+		stoppingOnce sync.Once
+	)
+
+	stopWatching := func() { stoppingOnce.Do(func() { close(stopping) }) }
+	defer stopWatching()
+
+	// every shutdown goes through here, so the deadline knows of it.
+	stopApp := func(sig os.Signal) (os.Signal, AppError) {
+		atomic.StoreInt32(&shuttingDown, 1)
+		stopWatching()
+		return shutdownApp(cmd, exited, options, sig, cancel)
+	}
+
+	if options["sd-notify"] != "" {
+		stopNotify, err := relayNotify(cmd)
+		if err != nil {
+			reportError(CannotStartApp, fmt.Sprintf("cannot relay notifications (%v)", err))
+			logExitReason(FailedToStart, nil)
+			return CannotStartApp
+		}
+		defer stopNotify()
+	}
+
+	// redirect apps's stdout/stderr to our stdout/stderr, respectively
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// the app's output is ours to copy (see appOutput).  this bounds Wait on a
+	// kill after a stalled shutdown.
+	cmd.WaitDelay = WAIT_DELAY
+
+	// the graceful shutdown comes first.  this is only its backstop.
+	stalled := func() error {
+		log.Println("Shutdown stalled. Killing app.")
+		return cmd.Process.Kill()
+	}
+	cmd.Cancel = stalled
+
+	var lines []*lineWriter // flushed once the app exits
+
+	maxLine := MAX_LINE
+	if options["max-line"] != "" {
+		maxLine, _ = strconv.Atoi(options["max-line"])
+	}
+	stderrMatched := make(chan string, 1)
+
+	match := func(line []byte) {}
+
+	if options["fail-on-stderr"] != "" {
+		pattern := regexp.MustCompile(options["fail-on-stderr"])
+
+		match = func(line []byte) {
+			if pattern.Match(line) {
+				select {
+				case stderrMatched <- string(bytes.TrimRight(line, "\r\n")):
+				default:
+					// already shutting down
+				}
+			}
+		}
+	}
+
+	switch {
+	case options["tag-streams"] != "":
+		// both streams may end up on our stdout, so keep records whole.
+		stdout := newSyncWriter(os.Stdout)
+		stderr := io.Writer(sharedStderr)
+		if options["merge-stderr"] != "" {
+			stderr = stdout
+		}
+
+		outTags := &streamTagger{stdout, "stdout", "", maxLine}
+		errTags := &streamTagger{stderr, "stderr", options["stderr-level"], maxLine}
+
+		if errTags.level != "" {
+			outTags.level = "info"
+		}
+
+		stdoutLines := newLineWriter(maxLine, outTags.writeLine)
+		stderrLines := newLineWriter(maxLine, func(line []byte) {
+			errTags.writeLine(line)
+			match(line)
+		})
+
+		cmd.Stdout, cmd.Stderr = stdoutLines, stderrLines
+		lines = append(lines, stdoutLines, stderrLines)
+	case options["fail-on-stderr"] != "":
+		// pass on whole lines, so they do not tear our log lines.
+		out, outFile := io.Writer(sharedStderr), os.Stderr
+		if options["merge-stderr"] != "" {
+			out, outFile = os.Stdout, os.Stdout
+		}
+
+		if options["colorize-child-levels"] != "" && isTerminal(outFile) {
+			out = levelColorWriter{out}
+		}
+
+		stderrLines := newLineWriter(maxLine, func(line []byte) {
+			out.Write(line)
+			match(line)
+		})
+
+		cmd.Stderr = stderrLines
+		if options["merge-stderr"] != "" {
+			cmd.Stdout = stderrLines
+		}
+
+		lines = append(lines, stderrLines)
+	case options["merge-stderr"] != "":
+		// one interleaved stream on our stdout.  exec gives the app a single
+		// pipe when both writers are the same.
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stdout
+	}
+
+	// color the app's output that goes straight to a terminal, by line.
+	if options["colorize-child-levels"] != "" {
+		if cmd.Stdout == os.Stdout && isTerminal(os.Stdout) {
+			colored := levelColorWriter{os.Stdout}
+			stdoutLines := newLineWriter(maxLine, func(line []byte) { colored.Write(line) })
+
+			// keep merged streams on one pipe.
+			if cmd.Stderr == cmd.Stdout {
+				cmd.Stderr = stdoutLines
+			}
+
+			cmd.Stdout = stdoutLines
+			lines = append(lines, stdoutLines)
+		}
+
+		if cmd.Stderr == os.Stderr && isTerminal(os.Stderr) {
+			colored := levelColorWriter{sharedStderr}
+			stderrLines := newLineWriter(maxLine, func(line []byte) { colored.Write(line) })
+
+			cmd.Stderr = stderrLines
+			lines = append(lines, stderrLines)
+		}
+	}
+
+	// a copy of stdout goes to the checker.  the output itself is untouched.
+	if options["validate-json-stdout"] != "" {
+		out := cmd.Stdout
+		if out == nil {
+			out = os.Stdout
+		}
+
+		checker := newLineWriter(maxLine, (&jsonChecker{max: maxLine}).check)
+		tee := io.MultiWriter(out, checker)
+
+		// keep merged streams on one pipe.
+		if cmd.Stderr == cmd.Stdout && cmd.Stdout != nil {
+			cmd.Stderr = tee
+		}
+
+		cmd.Stdout = tee
+		lines = append(lines, checker)
+	}
+
+	// a copy of the app's output, by line, for --syslog-include-app.
+	if options["syslog-include-app"] != "" {
+		if writer, err := openSyslog("syslog:local", options["syslog"], optionOr(options, "syslog-tag", SYSLOG_TAG)); err != nil {
+			log.Printf("Warning: cannot send app's output to syslog (%v).", err)
+		} else {
+			defer writer.Close()
+
+			out := cmd.Stdout
+			if out == nil {
+				out = os.Stdout
+			}
+
+			stdoutLines := newLineWriter(maxLine, func(line []byte) { writeSyslog(writer, line, false) })
+			tee := io.MultiWriter(out, stdoutLines)
+			lines = append(lines, stdoutLines)
+
+			// merged streams stay on one pipe, and are logged as stdout.
+			if cmd.Stderr == cmd.Stdout {
+				cmd.Stderr = tee
+			} else {
+				errOut := cmd.Stderr
+				if errOut == nil {
+					errOut = os.Stderr
+				}
+
+				stderrLines := newLineWriter(maxLine, func(line []byte) { writeSyslog(writer, line, true) })
+				cmd.Stderr = io.MultiWriter(errOut, stderrLines)
+				lines = append(lines, stderrLines)
+			}
+
+			cmd.Stdout = tee
+		}
+	}
+
+	// count last, so the counts are of what the app wrote.  merged streams
+	// are counted together, as stdout.
+	var stdoutCount, stderrCount *countingWriter
+
+	if options["count-output"] != "" {
+		stdoutCount = &countingWriter{w: cmd.Stdout}
+
+		if cmd.Stderr == cmd.Stdout {
+			cmd.Stderr = stdoutCount
+		} else {
+			stderrCount = &countingWriter{w: cmd.Stderr}
+			cmd.Stderr = stderrCount
+		}
+
+		cmd.Stdout = stdoutCount
+	}
+
+	// with --trace-fd, the first-output span ends on the app's first write to
+	// either stream.
+	var (
+		firstOutput sync.Once
+		appStarted  time.Time // as the start attempt that worked began
+	)
+
+	if tracer != nil {
+		first := func() { tracer.span("first-output", appStarted) }
+
+		stdout := &firstWriter{cmd.Stdout, &firstOutput, first}
+		if cmd.Stderr == cmd.Stdout {
+			cmd.Stderr = stdout
+		} else {
+			cmd.Stderr = &firstWriter{cmd.Stderr, &firstOutput, first}
+		}
+		cmd.Stdout = stdout
+	}
+
+	// outermost, so whatever the app writes is taken, even with no one left
+	// to read it.
+	if options["ignore-sigpipe"] != "" {
+		stdout := &pipeGuard{w: cmd.Stdout, name: "stdout"}
+		if cmd.Stderr == cmd.Stdout {
+			stdout.name = "output"
+			cmd.Stderr = stdout
+		} else {
+			cmd.Stderr = &pipeGuard{w: cmd.Stderr, name: "stderr"}
+		}
+		cmd.Stdout = stdout
+	}
+
+	// last, so the app writes to pipes of ours.  exited then closes once the
+	// app exits, and drained once its output ends.
+	var output appOutput
+	defer output.closeWriters()
+
+	stdoutPipe, err := output.pipe(cmd.Stdout)
+	if err == nil && cmd.Stderr == cmd.Stdout {
+		cmd.Stderr = stdoutPipe
+	} else if err == nil {
+		cmd.Stderr, err = output.pipe(cmd.Stderr)
+	}
+	cmd.Stdout = stdoutPipe
+
+	if err != nil {
+		reportError(CannotStartApp, fmt.Sprintf("cannot pipe app's output (%v)", err))
+		return CannotStartApp
+	}
+
+	var (
+		stdinClosed <-chan struct{}
+		stdinGrace  <-chan time.Time
+	)
+
+	if options["stop-on-stdin-close"] != "" {
+		if stdinIsNull() {
+			log.Println("Warning: stdin is empty (/dev/null), so it never closes. Run the container with -i to keep stdin open.")
+		} else {
+			stdin, closed, err := forwardStdin()
+			if err != nil {
+				reportError(CannotStartApp, fmt.Sprintf("cannot forward stdin (%v)", err))
+				return CannotStartApp
+			}
+
+			// the app has its own copy once started.
+			defer stdin.Close()
+
+			cmd.Stdin, stdinClosed = stdin, closed
+		}
+	}
+
+	if options["start-delay"] != "" {
+		delay, _ := time.ParseDuration(options["start-delay"])
+
+		endDelay := tracer.begin("start-delay")
+		waited := waitStartDelay(delay, sigs, deadline, options)
+		endDelay()
+
+		if !waited {
+			return OK
+		}
+	}
+
+	// start the app before monitoring, so signals always have a process to
+	// go to.
+	retries, _ := strconv.Atoi(optionOr(options, "start-retries", "0"))
+	retryDelay, _ := time.ParseDuration(optionOr(options, "start-retry-delay", START_RETRY_DELAY.String()))
+
+	if options["no-auto-env"] == "" {
+		cmd.Env = append(cmd.Env, "DRA_STARTED_AT="+time.Now().Format(time.RFC3339))
+	}
+
+	endStart := tracer.begin("start")
+
+	for attempt := 1; ; attempt++ {
+		appStarted = time.Now()
+
+		err := startCommand(cmd)
+		if err == nil {
+			break
+		}
+
+		if attempt > retries || !isTransientStartError(err) {
+			code := CannotStartApp
+			if isInvalidCommandError(err) {
+				code = InvalidCommand
+			}
+
+			hint := ""
+			if options["chroot"] != "" && errors.Is(err, syscall.EPERM) {
+				hint = "; --chroot needs root or CAP_SYS_CHROOT"
+			} else if options["chroot"] != "" && errors.Is(err, fs.ErrNotExist) {
+				hint = "; COMMAND must be a path inside the --chroot directory"
+			}
+
+			reportError(code, fmt.Sprintf("cannot start app (%v)%s", err, hint))
+			logExitReason(FailedToStart, nil)
+			return code
+		}
+
+		log.Printf("Cannot start app (%v). Retry %d of %d.", err, attempt, retries)
+
+		if !waitStartDelay(retryDelay, sigs, deadline, options) {
+			return OK
+		}
+
+		// a Cmd cannot be started twice.
+		cmd = cloneCommand(ctx, cmd)
+		cmd.Cancel = stalled
+	}
+
+	endStart()
+	log.Println("App started.")
+
+	// the app has its own ends now.  ours would keep its output open.
+	output.closeWriters()
+
+	// all output is copied by the time we return.  see drained.
+	if stdoutCount != nil {
+		defer func() {
+			if stderrCount == nil {
+				log.Printf("App output (stdout and stderr merged, %v).", stdoutCount)
+			} else {
+				log.Printf("App output (stdout %v, stderr %v).", stdoutCount, stderrCount)
+			}
+		}()
+	}
+
+	// with --sd-notify the app reports readiness itself.
+	if options["sd-notify"] == "" {
+		stopWatchdog := notifyStarted()
+		defer stopWatchdog()
+	}
+
+	if options["post-start-signal"] != "" {
+		sig, _ := parseSignal(options["post-start-signal"])
+
+		// give the app a moment to install its signal handlers.
+		time.AfterFunc(POST_START_DELAY, func() {
+			log.Printf("Sending post-start signal (%v) to app.", sig)
+
+			if err := cmd.Process.Signal(sig); err != nil {
+				log.Printf("Cannot send post-start signal (%v) to app: %v", sig, err)
+			}
+		})
+	}
+
+	postStartFailed := make(chan error, 1)
+
+	if options["post-start"] != "" {
+		stopHook := runPostStart(options["post-start"], func(err error) {
+			if options["post-start-required"] != "" {
+				postStartFailed <- err
+			}
+		})
+
+		// the hook dies with us, e.g. on a shutdown while it runs.
+		defer stopHook()
+	}
+
+	// wait for the app from goroutine, so we can monitor signals and app
+	// termination.  waitErr is safe to read once exited is closed.
+	var waitErr error
+
+	go func() {
+		waitErr = cmd.Wait()
+		tracer.span("run", appStarted)
+		close(exited)
+	}()
+
+	// drained is closed once all of the app's output is passed on.  a
+	// descendant that keeps it open holds this up, not exited.
+	drained := make(chan struct{})
+
+	go func() {
+		<-exited
+		output.drain(WAIT_DELAY)
+		for _, w := range lines {
+			w.Flush()
+		}
+		close(drained)
+	}()
+
+	defer func() { <-drained }()
+
+	started := time.Now()
+
+	// a sign of life in otherwise silent logs.  only ticks while we wait on
+	// the app, not during shutdown.
+	var heartbeat <-chan time.Time
+
+	if options["heartbeat-interval"] != "" {
+		interval, _ := time.ParseDuration(options["heartbeat-interval"])
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		heartbeat = ticker.C
+	}
+
+	var fileChanged <-chan struct{}
+
+	if options["watch-file"] != "" {
+		fileChanged = watchFile(options["watch-file"], WATCH_INTERVAL, exited)
+	}
+
+	var controlSignals <-chan os.Signal
+
+	if control != nil {
+		controlSignals = serveControl(control, cmd.Process.Pid, started, stopping)
+	}
+
+	// monitor termination of app or signals from docker
+	for {
+		select {
+		case <-exited:
+			// say the app stopped after its last output.
+			<-drained
+
+			if options["report-usage"] != "" {
+				reportUsage(cmd.ProcessState)
+			}
+
+			result := OK
+
+			if waitErr == nil {
+				log.Println("App stopped.")
+			} else {
+				log.Printf("App stopped with error (%v)", waitErr)
+				result = AppStoppedWithError
+			}
+
+			// exiting on its own this soon, even cleanly, looks like a crash.
+			if options["min-runtime"] != "" {
+				minRuntime, _ := time.ParseDuration(options["min-runtime"])
+
+				if ran := time.Since(appStarted); ran < minRuntime {
+					log.Printf("Warning: app exited after only %v, less than --min-runtime (%v).", ran.Round(time.Millisecond), minRuntime)
+				}
+			}
+
+			logExitReason(classifyExit(cmd.ProcessState, false, OK), cmd.ProcessState)
+
+			if options["hold-open-on-exit"] != "" {
+				holdOpen(cmd.ProcessState, sigs, deadline, options)
+			}
+
+			return result
+		case line := <-stderrMatched:
+			log.Printf("App wrote fatal pattern to stderr (%s).", line)
+
+			_, err := stopApp(syscall.SIGTERM)
+			if err != OK {
+				log.Println(err)
+			}
+
+			state := finishedState(cmd, exited)
+			logExitReason(classifyExit(state, true, err), state)
+
+			return StderrMatched
+		case <-postStartFailed:
+			_, err := stopApp(syscall.SIGTERM)
+			if err != OK {
+				log.Println(err)
+			}
+
+			state := finishedState(cmd, exited)
+			logExitReason(classifyExit(state, true, err), state)
+
+			return PostStartFailed
+		case <-heartbeat:
+			log.Printf("App still running (pid %d, uptime %v).", cmd.Process.Pid, time.Since(started).Round(time.Second))
+		case <-fileChanged:
+			sig, _ := parseSignal(optionOr(options, "watch-signal", "SIGHUP"))
+
+			log.Printf("Watched file changed (%s).", options["watch-file"])
+			forwardSignal(cmd.Process, sig)
+		case sig := <-controlSignals:
+			forwardSignal(cmd.Process, sig)
+		case <-deadline:
+			_, err := stopApp(syscall.SIGTERM)
+			if err != OK {
+				log.Println(err)
+			}
+
+			state := finishedState(cmd, exited)
+			logExitReason(classifyExit(state, true, err), state)
+
+			return DeadlineExceeded
+		case <-stdinClosed:
+			// the app sees end of input too.  let it finish its output and
+			// exit on its own before we stop it.
+			log.Println("Stdin closed.")
+
+			stdinClosed, stdinGrace = nil, time.After(STDIN_CLOSE_GRACE)
+		case <-stdinGrace:
+			log.Printf("App still running %v after stdin closed.", STDIN_CLOSE_GRACE)
+
+			_, err := stopApp(syscall.SIGTERM)
+			if err != OK {
+				log.Println(err)
+			}
+
+			state := finishedState(cmd, exited)
+			logExitReason(classifyExit(state, true, err), state)
+
+			return err
+		case sig := <-sigs:
+			if isForwardedSignal(options, sig) {
+				forwardSignal(cmd.Process, mapSignal(options, sig))
+				continue
+			}
+
+			log.Printf("Received signal (%v).", sig)
+
+			// the app stops on the mapped signal.  the post-stop hook still
+			// learns what we received.
+			stopSig := mapSignal(options, sig)
+			if stopSig != sig {
+				log.Printf("Mapping signal (%v) to (%v).", sig, stopSig)
+			}
+
+			sigSuccess, err := stopApp(stopSig)
+
+			// a killed app is reaped soon after.  wait a little to say how it
+			// ended.
+			if err == FailedToKillApp {
+				select {
+				case <-exited:
+				case <-time.After(SIG_TIMEOUT):
+				}
+			}
+
+			state := finishedState(cmd, exited)
+
+			// deferred, to run once the outcome is logged, on any return below.
+			if options["post-stop"] != "" {
+				timeout, _ := time.ParseDuration(optionOr(options, "post-stop-timeout", POST_STOP_TIMEOUT.String()))
+				defer runPostStop(options["post-stop"], sig, state, timeout)
+			}
+
+			if err != OK {
+				log.Println(err)
+
+				if err == FailedToKillApp {
+					log.Printf("App killed, %s.", exitDetail(state))
+				}
+
+				logExitReason(classifyExit(state, true, err), state)
+
+				// with --tolerate-escalation, a kill that worked is fine too.
+				if err == FailedToKillApp && state != nil && options["tolerate-escalation"] != "" {
+					return OK
+				}
+
+				// a kill that alerting should tell apart from other failures.
+				if err == FailedToKillApp && options["kill-exit-code"] != "" {
+					code, _ := strconv.Atoi(options["kill-exit-code"])
+					return AppError(code)
+				}
+
+				return err
+			}
+
+			log.Printf("App stopped with signal (%v), %s.\n", sigSuccess, exitDetail(state))
+
+			if options["report-usage"] != "" {
+				reportUsage(state)
+			}
+
+			logExitReason(classifyExit(state, true, err), state)
+
+			// did app fail on its way out?
+			if options["ignore-shutdown-exit-code"] == "" && failedOnSignal(state, sigSuccess) {
+				log.Printf("App failed while stopping (%s).", exitDetail(state))
+				return AppStoppedWithError
+			}
+
+			// did app stop with the expected signal?  any will do with
+			// --tolerate-escalation.
+			switch {
+			case sigSuccess == stopSig:
+				return OK
+			case sigSuccess == stopSteps(options, stopSig)[0].Signal:
+				return OK
+			case sigSuccess == syscall.SIGINT:
+				return OK
+			case options["tolerate-escalation"] != "":
+				return OK
+			default:
+				return InsufficientSignalError
+			}
+		}
+	}
+}
+
+// failedOnSignal
+//
+//  Report whether an app stopped by sig exited with an error code.  Dying of
+//  a signal is no error, and neither is exit code 128+sig, which shells and
+//  runtimes such as the JVM use to say the same.
+//
+func failedOnSignal(state *os.ProcessState, sig os.Signal) bool {
+	if state == nil {
+		return false
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return false
+	}
+
+	code := state.ExitCode()
+
+	if s, ok := sig.(syscall.Signal); ok && code == 128+int(s) {
+		return false
+	}
+
+	return code != 0
+}
+
+// reportUsage
+//
+//  Log the CPU time and peak memory of an app that has finished, then our own.
+//  state is nil if the app was not waited on (e.g. it is still being killed).
+//
+func reportUsage(state *os.ProcessState) {
+	if state == nil {
+		log.Println("App resource usage unavailable (app did not finish).")
+		return
+	}
+
+	if usage := usageString(state); usage != "" {
+		log.Printf("App resource usage (%s).", usage)
+	} else {
+		log.Printf("App resource usage (user %v, system %v).", state.UserTime(), state.SystemTime())
+	}
+
+	// ours too, to size the container's limits for both of us.
+	if usage := selfUsageString(); usage != "" {
+		log.Printf("Supervisor resource usage (%s).", usage)
+	}
+}
+
+// finishedState
+//
+//  Return the app's final state, or nil if Wait has not returned yet (e.g.
+//  the app is still dying from SIGKILL).
+//
+func finishedState(cmd *exec.Cmd, exited <-chan struct{}) *os.ProcessState {
+	select {
+	case <-exited:
+		return cmd.ProcessState
+	default:
+		return nil
+	}
+}
+
+// shutdownApp
+//
+//  Drain and stop the app, and any worker in --stop-pidfile, as if sig was
+//  received.  Should all that stall, cancel is called to kill the app.
+//  Returns the signal that stopped the app.
+//
+func shutdownApp(cmd *exec.Cmd, exited <-chan struct{}, options map[string]string, sig os.Signal, cancel context.CancelFunc) (os.Signal, AppError) {
+	var (
+		drain time.Duration
+		limit *time.Timer
+		out   int32
+	)
+
+	defer tracer.begin("shutdown")()
+
+	steps := stopSteps(options, sig)
+	forceKill := options["no-force-kill"] == ""
+	rounds := 1
+
+	if options["stop-pidfile"] != "" {
+		rounds = 2
+	}
+
+	if options["drain-socket"] != "" {
+		drain, _ = time.ParseDuration(optionOr(options, "drain-timeout", DRAIN_TIMEOUT.String()))
+	}
+
+	if options["shutdown-budget"] != "" {
+		budget, _ := time.ParseDuration(options["shutdown-budget"])
+
+		drain, steps = allocateBudget(budget, drain, steps, rounds)
+		logBudget(budget, drain, steps)
+
+		// hard ceiling, whatever phase we are in.
+		if forceKill {
+			limit = time.AfterFunc(budget, func() {
+				atomic.StoreInt32(&out, 1)
+				log.Printf("Shutdown budget (%v) spent. Killing app.", budget)
+				cmd.Process.Kill()
+			})
+			defer limit.Stop()
+		}
+	}
+
+	if forceKill {
+		stalled := time.AfterFunc(shutdownTime(drain, steps, rounds)+SIG_TIMEOUT, cancel)
+		defer stalled.Stop()
+	}
+
+	if options["drain-socket"] != "" {
+		endDrain := tracer.begin("drain")
+		drainApp(options["drain-socket"], optionOr(options, "drain-message", "drain"), drain)
+		endDrain()
+	}
+
+	if options["stop-pidfile"] != "" {
+		endPidFile := tracer.begin("stop-pidfile")
+		stopPidFile(options["stop-pidfile"], forceKill, steps)
+		endPidFile()
+	}
+
+	endStop := tracer.begin("stop-app")
+	stopSig, err := stopProcess(cmd.Process, exited, forceKill, steps...)
+	endStop()
+
+	if err == InsufficientSignalError {
+		// leave the hard kill to docker, so operators see who did it.
+		log.Println("App ignored stop signals. Waiting for it to exit without killing it.")
+		<-exited
+	}
+
+	if atomic.LoadInt32(&out) != 0 {
+		return nil, FailedToKillApp
+	}
+
+	return stopSig, err
+}
+
+// shutdownTime is the longest the drain and rounds of steps should take.
+func shutdownTime(drain time.Duration, steps []StopStep, rounds int) time.Duration {
+	total := drain
+
+	for _, step := range steps {
+		total += step.Timeout * time.Duration(rounds)
+	}
+
+	return total
+}
+
+// allocateBudget
+//
+//  Fit the drain timeout and stop steps into budget.  If they would take
+//  longer, every phase is scaled down by the same factor.  rounds is how many
+//  times the steps run, e.g. 2 with --stop-pidfile.
+//
+func allocateBudget(budget time.Duration, drain time.Duration, steps []StopStep, rounds int) (time.Duration, []StopStep) {
+	total := shutdownTime(drain, steps, rounds)
+
+	if total <= budget {
+		return drain, steps
+	}
+
+	scale := func(d time.Duration) time.Duration {
+		return time.Duration(float64(d) * float64(budget) / float64(total))
+	}
+
+	scaled := make([]StopStep, len(steps))
+
+	for i, step := range steps {
+		scaled[i] = StopStep{step.Signal, scale(step.Timeout)}
+	}
+
+	return scale(drain), scaled
+}
+
+// logBudget logs how the shutdown budget was split between phases.
+func logBudget(budget time.Duration, drain time.Duration, steps []StopStep) {
+	phases := []string{}
+
+	if drain > 0 {
+		phases = append(phases, fmt.Sprintf("drain %v", drain))
+	}
+
+	for _, step := range steps {
+		phases = append(phases, fmt.Sprintf("%v %v", step.Signal, step.Timeout))
+	}
+
+	log.Printf("Shutdown budget (%v): %s.", budget, strings.Join(phases, ", "))
+}
+
+// runPostStart
+//
+//  Start the --post-start hook with /bin/sh -c, alongside the app, e.g. to
+//  register it with service discovery.  The hook's output is ours.  failed is
+//  called if the hook cannot start or fails.  Returns a func that kills the
+//  hook, and anything it started, if it is still running.
+//
+func runPostStart(hook string, failed func(err error)) func() {
+	log.Printf("Running post-start hook (%s).", hook)
+
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = sharedStderr
+	cmd.SysProcAttr = groupProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Cannot start post-start hook (%v).", err)
+		failed(err)
+		return func() {}
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if err := cmd.Wait(); err != nil {
+			log.Printf("Post-start hook failed (%v).", err)
+			failed(err)
+			return
+		}
+
+		log.Println("Post-start hook succeeded.")
+	}()
+
+	return func() {
+		select {
+		case <-done:
+		default:
+			log.Println("Killing post-start hook.")
+			killGroup(cmd.Process)
+		}
+	}
+}
+
+// runPostStop
+//
+//  Run the --post-stop hook with /bin/sh -c once a shutdown we started on sig
+//  is over, e.g. to deregister the app.  The hook learns how it went from
+//  DRA_STOP_SIGNAL, and DRA_EXIT_CODE or DRA_EXIT_SIGNAL, both empty if the app
+//  was not seen to exit.  The hook, and anything it started, is killed after
+//  timeout.  Its failure is only logged; the app is gone already.
+//
+func runPostStop(hook string, sig os.Signal, state *os.ProcessState, timeout time.Duration) {
+	var exitCode, exitSignal string
+
+	if state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			exitSignal = signalName(status.Signal())
+		} else {
+			exitCode = strconv.Itoa(state.ExitCode())
+		}
+	}
+
+	log.Printf("Running post-stop hook (%s).", hook)
+
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	cmd.Env = append(os.Environ(), "DRA_STOP_SIGNAL="+signalName(sig), "DRA_EXIT_CODE="+exitCode, "DRA_EXIT_SIGNAL="+exitSignal)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = sharedStderr
+	cmd.SysProcAttr = groupProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		log.Printf("Cannot start post-stop hook (%v).", err)
+		return
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		log.Printf("Post-stop hook still running after %v. Killing it.", timeout)
+		killGroup(cmd.Process)
+	})
+	defer timer.Stop()
+
+	if err := cmd.Wait(); err != nil {
+		log.Printf("Post-stop hook failed (%v).", err)
+		return
+	}
+
+	log.Println("Post-stop hook succeeded.")
+}
+
+// stdinIsNull reports whether our stdin is /dev/null, i.e. empty, not closed.
+func stdinIsNull() bool {
+	stdin, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+
+	return err == nil && os.SameFile(stdin, null)
+}
+
+// forwardStdin
+//
+//  Give the app a pipe for stdin and copy our stdin into it.  The channel is
+//  closed when our stdin closes (e.g. the terminal went away), and the app then
+//  sees EOF.  An app that does not read its stdin does not count as closed.
+//
+func forwardStdin() (*os.File, <-chan struct{}, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closed := make(chan struct{})
+
+	go func() {
+		buf := make([]byte, 32*1024)
+
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				// the app may have closed its end.  keep reading ours.
+				w.Write(buf[:n])
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		w.Close()
+		close(closed)
+	}()
+
+	return r, closed, nil
+}
+
+// isTransientStartError
+//
+//  Report whether a failed Start may succeed later, e.g. the app is on a
+//  mount that is not ready yet.
+//
+func isTransientStartError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ETXTBSY)
+}
+
+// isInvalidCommandError reports whether Start failed because COMMAND is missing
+// or cannot be executed, rather than for lack of resources.
+func isInvalidCommandError(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.ENOEXEC) || errors.Is(err, syscall.EISDIR)
+}
+
+// cloneCommand returns an unstarted copy of cmd, killed if ctx is canceled.
+// cmd.Cancel is not copied, as it may kill cmd's process rather than the clone's.
+func cloneCommand(ctx context.Context, cmd *exec.Cmd) *exec.Cmd {
+	clone := exec.CommandContext(ctx, cmd.Path)
+
+	clone.Path = cmd.Path
+	clone.Args = cmd.Args
+	clone.Env = cmd.Env
+	clone.Dir = cmd.Dir
+	clone.Stdin = cmd.Stdin
+	clone.Stdout = cmd.Stdout
+	clone.Stderr = cmd.Stderr
+	clone.ExtraFiles = cmd.ExtraFiles
+	clone.SysProcAttr = cmd.SysProcAttr
+	clone.WaitDelay = cmd.WaitDelay
+	clone.Err = cmd.Err
+
+	return clone
+}
+
+// waitStartDelay
+//
+//  Sleep for delay before the app starts.  Returns false if a shutdown signal
+//  arrived, or deadline was closed, first, in which case the app must not
+//  start.
+//
+func waitStartDelay(delay time.Duration, sigs chan os.Signal, deadline <-chan struct{}, options map[string]string) bool {
+	log.Printf("Delaying app start (%v).", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-deadline:
+			return false
+		case sig := <-sigs:
+			if isForwardedSignal(options, sig) {
+				// nothing to forward to yet.
+				continue
+			}
+
+			log.Printf("Received signal (%v) before app started.", sig)
+			return false
+		}
+	}
+}
+
+// holdOpen
+//
+//  Keep docker-run-app, and so the container, running after the app exited,
+//  until a signal that would stop the app arrives, or deadline is closed.
+//  Meanwhile the container can be inspected with docker exec.
+//
+func holdOpen(state *os.ProcessState, sigs chan os.Signal, deadline <-chan struct{}, options map[string]string) {
+	log.Printf("Holding open after app exited (exit code %d). Send SIGTERM to exit.", state.ExitCode())
+
+	for {
+		select {
+		case <-deadline:
+			return
+		case sig := <-sigs:
+			if isForwardedSignal(options, sig) {
+				// no app to forward to.
+				continue
+			}
+
+			log.Printf("Received signal (%v) while holding open.", sig)
+			return
+		}
+	}
+}
+
+// isForwardedSignal
+//
+//  Report whether sig goes straight to the app rather than stopping it.  With
+//  --trap-all only SIGTERM stops the app.  With --forward-all-signals SIGINT
+//  does too.  Other signals in --signal-map are always forwarded.
+//
+func isForwardedSignal(options map[string]string, sig os.Signal) bool {
+	mapping, _ := parseSignalMap(options["signal-map"])
+	_, mapped := mapping[sig]
+
+	switch {
+	case sig == syscall.SIGTERM:
+		return false
+	case sig == syscall.SIGINT:
+		return options["trap-all"] != "" && options["forward-all-signals"] == ""
+	default:
+		return mapped || options["trap-all"] != "" || options["forward-all-signals"] != ""
+	}
+}
+
+// forwardSignal
+//
+//  Relay sig to the app verbatim.  Signals that only concern docker-run-app
+//  (e.g. SIGCHLD from the app itself) are dropped.
+//
+func forwardSignal(p *os.Process, sig os.Signal) {
+	if isPrivateSignal(sig) {
+		return
+	}
+
+	log.Printf("Forwarding signal (%v) to app.", sig)
+
+	if err := p.Signal(sig); err != nil {
+		log.Printf("Cannot forward signal (%v) to app: %v", sig, err)
+	}
+}
+
+// drainApp
+//
+//  Ask the app to finish in-flight work before it is signaled: connect to its
+//  unix socket, send message, and wait up to timeout for the app to close the
+//  connection.  Any failure falls through to the normal shutdown.
+//
+func drainApp(socket string, message string, timeout time.Duration) {
+	log.Printf("Draining app through socket (%s).", socket)
+
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		log.Printf("Cannot connect to drain socket (%s): %v", socket, err)
+		return
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = io.WriteString(conn, message+"\n"); err != nil {
+		log.Printf("Cannot send drain message to socket (%s): %v", socket, err)
+		return
+	}
+
+	// the app closes the connection once it has drained.
+	if _, err = io.Copy(io.Discard, conn); err != nil {
+		log.Printf("App did not finish draining (%v).", err)
+		return
+	}
+
+	log.Println("App drained.")
+}
+
+// stopPidFile
+//
+//  Stop the process whose pid is written in file (e.g. an app's worker) with
+//  the same escalation used for the app, including the final kill if
+//  forceKill.  A missing or empty file means there is no such process, and is
+//  not an error.
+//
+func stopPidFile(file string, forceKill bool, steps []StopStep) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("Cannot read pid file (%s): %v", file, err)
+		return
+	}
+
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		log.Printf("Pid file (%s) is empty.", file)
+		return
+	}
+
+	pid, err := strconv.Atoi(text)
+	if err != nil || pid <= 0 {
+		log.Printf("Pid file (%s) does not hold a pid (%q).", file, text)
+		return
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		log.Printf("Cannot find process (%d) from pid file (%s): %v", pid, file, err)
+		return
+	}
+
+	log.Printf("Stopping process (%d) from pid file (%s).", pid, file)
+
+	if sigSuccess, err := stopProcess(p, watchProcess(p), forceKill, steps...); err == InsufficientSignalError {
+		log.Printf("Process (%d) ignored stop signals. Not killing it.", pid)
+	} else if err != OK {
+		log.Println(err)
+	} else {
+		log.Printf("Process (%d) stopped with signal (%v).", pid, sigSuccess)
+	}
+}
+
+// stopSteps
+//
+//  The shutdown escalation: --stop-signals if given, otherwise the signal
+//  received, SIGTERM, then SIGHUP, SIG_TIMEOUT apart.  A signal set in the
+//  variable named by --stop-signal-env replaces the signal received.
+//
+func stopSteps(options map[string]string, sig os.Signal) []StopStep {
+	if options["stop-signals"] != "" {
+		steps, _ := parseStopSignals(options["stop-signals"])
+		return steps
+	}
+
+	if name := options["stop-signal-env"]; name != "" && os.Getenv(name) != "" {
+		sig, _ = parseSignal(os.Getenv(name))
+	}
+
+	return []StopStep{
+		{sig, SIG_TIMEOUT},
+		{syscall.SIGTERM, SIG_TIMEOUT},
+		{syscall.SIGHUP, SIG_TIMEOUT},
+	}
+}
+
+// parseStopSignals
+//
+//  Parse a list of signals with optional timeouts, e.g. "SIGTERM:10s,SIGKILL:0".
+//  A signal without a timeout waits SIG_TIMEOUT.
+//
+func parseStopSignals(spec string) ([]StopStep, error) {
+	var steps []StopStep
+
+	for _, item := range strings.Split(spec, ",") {
+		step := StopStep{Timeout: SIG_TIMEOUT}
+		name, timeout, hasTimeout := strings.Cut(item, ":")
+
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		step.Signal = sig
+
+		if hasTimeout {
+			if step.Timeout, err = time.ParseDuration(strings.TrimSpace(timeout)); err != nil || step.Timeout < 0 {
+				return nil, fmt.Errorf("invalid timeout (%s) for signal (%s)", timeout, name)
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// parseSignalMap
+//
+//  Parse a --signal-map list of received signals and what to send the app
+//  instead, e.g. "SIGINT=SIGTERM,SIGTERM=SIGQUIT".  Signals we cannot catch, or
+//  that are ours alone (see isPrivateSignal), cannot be mapped.
+//
+func parseSignalMap(spec string) (map[os.Signal]os.Signal, error) {
+	mapping := make(map[os.Signal]os.Signal)
+
+	if spec == "" {
+		return mapping, nil
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		fromName, toName, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid mapping (%s)", item)
+		}
+
+		from, err := parseSignal(fromName)
+		if err != nil {
+			return nil, err
+		}
+
+		to, err := parseSignal(toName)
+		if err != nil {
+			return nil, err
+		}
+
+		// by name, as not every platform has SIGSTOP.
+		if name := signalName(from); name == "SIGKILL" || name == "SIGSTOP" || isPrivateSignal(from) {
+			return nil, fmt.Errorf("unmappable signal (%s)", strings.TrimSpace(fromName))
+		}
+
+		if _, dup := mapping[from]; dup {
+			return nil, fmt.Errorf("invalid mapping (%s), signal already mapped", item)
+		}
+
+		mapping[from] = to
+	}
+
+	return mapping, nil
+}
+
+// mapSignal returns the signal the app gets for sig under --signal-map.
+func mapSignal(options map[string]string, sig os.Signal) os.Signal {
+	mapping, _ := parseSignalMap(options["signal-map"])
+
+	if to, ok := mapping[sig]; ok {
+		return to
+	}
+
+	return sig
+}
+
+/** stopProcess
+ *
+ * given a process, a channel closed once it exits, and an ordered list of
+ * steps, send each step's signal in turn and wait up to the step's timeout
+ * for the process to stop, logging how each step went.  if it never does,
+ * kill it, or with forceKill false, return InsufficientSignalError and leave
+ * it running.  SIGKILL as a step kills it just the same, so the app stopping
+ * on it returns FailedToKillApp too, and with forceKill false such a step is
+ * skipped.  a step with a 0 timeout moves straight on to the next without
+ * looking, except SIGKILL, which cannot be ignored, so it is always given
+ * SIG_TIMEOUT or more to take.
+ */
+func stopProcess(p Stoppable, exited <-chan struct{}, forceKill bool, steps ...StopStep) (os.Signal, AppError) {
+	for _, step := range steps {
+		// e.g. from --stop-signal-env, which parseFlags cannot check.
+		if step.Signal == syscall.SIGKILL && !forceKill {
+			log.Println("Not sending signal (killed), as app may not be killed.")
+			continue
+		}
+
+		log.Printf("Attempting to stop app with signal (%v).", step.Signal)
+
+		start := time.Now()
+
+		if err := p.Signal(step.Signal); err != nil {
+			// did the app exit before the signal got there?
+			select {
+			case <-exited:
+				log.Printf("App exited before signal (%v) was delivered.", step.Signal)
+				return step.Signal, OK
+			default:
+				log.Printf("Cannot deliver signal (%v): %v", step.Signal, err)
+				continue
+			}
+		}
+
+		wait := step.Timeout
+		if step.Signal == syscall.SIGKILL && wait < SIG_TIMEOUT {
+			wait = SIG_TIMEOUT
+		}
+
+		// racing a 0 timer against exited would make the outcome a coin toss.
+		if wait == 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-exited:
+			timer.Stop()
+			log.Printf("App exited %v after signal (%v).", time.Since(start).Round(time.Millisecond), step.Signal)
+
+			// a hard kill, whether a step or we sent it.
+			if step.Signal == syscall.SIGKILL {
+				return step.Signal, FailedToKillApp
+			}
+
+			return step.Signal, OK
+		case <-timer.C:
+			log.Printf("App still running %v after signal (%v).", time.Since(start).Round(time.Millisecond), step.Signal)
+		}
+	}
+
+	if !forceKill {
+		return nil, InsufficientSignalError
+	}
+
+	// out of signals.  a process already done was killed by the last step,
+	// e.g. SIGKILL.
+	if err := p.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Println("Failed to kill app: ", err)
+	}
+
+	return nil, FailedToKillApp
+}
+
+func usage() {
+	var (
+		shortFlags string
+		synopsis   []string
+		width      = len("COMMAND")
+	)
+
+	prog := path.Base(os.Args[0])
+
+	for _, info := range flagInfos {
+		// short flags without params are grouped, e.g. [-hV].
+		if info.Param == "" && len(info.Flags[0]) == 2 {
+			shortFlags += info.Flags[0][1:]
+		} else if info.Param == "" {
+			synopsis = append(synopsis, fmt.Sprintf("[%s]", info.Flags[len(info.Flags)-1]))
+		} else {
+			synopsis = append(synopsis, fmt.Sprintf("[%s %s]", info.Flags[len(info.Flags)-1], info.Param))
+		}
+
+		if len(info.usageName()) > width {
+			width = len(info.usageName())
+		}
+	}
+
+	synopsis = append([]string{"[-" + shortFlags + "]"}, synopsis...)
+	synopsis = append(synopsis, "[--]", "COMMAND")
+
+	// wrap synopsis at 80 columns
+	line := fmt.Sprintf("Usage:     %s", prog)
+	indent := strings.Repeat(" ", len(line))
+
+	for _, word := range synopsis {
+		if len(line)+1+len(word) > 80 && line != indent {
+			fmt.Println(line)
+			line = indent
+		}
+		line += " " + word
+	}
+
+	fmt.Println(line)
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println()
+	fmt.Printf("  %-*s - app and args to execute. app is looked up in PATH if it has no slash.\n", width, "COMMAND")
+	fmt.Printf("  %-*s - args after this flag are reserved for COMMAND.\n", width, "--")
+
+	for _, info := range flagInfos {
+		fmt.Printf("  %-*s - %s\n", width, info.usageName(), strings.Replace(info.Description, "docker-run-app", prog, -1))
+	}
+
+	fmt.Println()
+	fmt.Println("Exit codes:")
+	fmt.Println()
+
+	for _, code := range exitCodes {
+		fmt.Printf("  %-*d - %s.\n", width, code, code.Error())
+	}
+
+	fmt.Println()
+}
+
+// usageJSON
+//
+//  Print flagInfos as a JSON array for tools that generate wrappers around
+//  docker-run-app.
+//
+func usageJSON() {
+	type flagJSON struct {
+		Name        string   `json:"name"`
+		Flags       []string `json:"flags"`
+		TakesParam  bool     `json:"takes_param"`
+		Param       string   `json:"param,omitempty"`
+		Description string   `json:"description"`
+		Repeatable  bool     `json:"repeatable,omitempty"`
+	}
+
+	list := make([]flagJSON, len(flagInfos))
+
+	for i, info := range flagInfos {
+		list[i] = flagJSON{info.Name, info.Flags, info.Param != "", info.Param, info.Description, info.repeatable()}
+	}
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(string(data))
 }
 
 func version() {
@@ -354,10 +3050,30 @@ func version() {
 
 func (err AppError) Error() string {
 	switch err {
+	case OK:
+		return "app exited, or was stopped, cleanly"
+	case AppStoppedWithError:
+		return "app exited with an error"
+	case CannotStartApp:
+		return "cannot start app"
+	case InvalidCommand:
+		return "command not found or not executable"
+	case BadFlag:
+		return "bad flag"
+	case FailedToKillApp:
+		return "app ignored stop signals and was killed"
 	case MissingArgument:
 		return "missing argument"
+	case StderrMatched:
+		return "app wrote --fail-on-stderr pattern"
+	case PostStartFailed:
+		return "required post-start hook failed"
+	case AlreadyRunning:
+		return "another instance holds --lock-file"
+	case DeadlineExceeded:
+		return "--deadline passed"
 	case InsufficientSignalError:
-		return "SIGINT insufficient to stop app"
+		return "stop signals were insufficient to stop app"
 	default:
 		return "unknown error"
 	}
@@ -371,6 +3087,8 @@ func (err FlagError) Error() string {
 		return "flag not found"
 	case FlagHasTooFewParams:
 		return "flag is missing required parameters"
+	case FlagParamIsFlag:
+		return "flag has another flag for a parameter"
 	default:
 		return "unknown error"
 	}
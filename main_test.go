@@ -0,0 +1,1898 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the test binary runs main, not the tests, when this is set.  see runMain.
+const testMainEnv = "DOCKER_RUN_APP_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testMainEnv) != "" {
+		main()
+	}
+
+	os.Exit(m.Run())
+}
+
+// runMain
+//
+//  Run docker-run-app with args in a process of its own, as main exits, and
+//  return what it wrote and its exit code.  stdin is "" for /dev/null.
+//
+func runMain(t *testing.T, stdin string, args ...string) (stdout string, stderr string, code int) {
+	t.Helper()
+
+	var outBuf, errBuf bytes.Buffer
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), testMainEnv+"=1")
+	cmd.Stdout, cmd.Stderr = &outBuf, &errBuf
+
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("cannot run docker-run-app: %v", err)
+	}
+
+	return outBuf.String(), errBuf.String(), cmd.ProcessState.ExitCode()
+}
+
+func TestTraceFdWithIgnoreSigpipe(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--trace-fd", "2", "--ignore-sigpipe", "--", "/bin/echo", "hi")
+
+	if code != int(OK) || stdout != "hi\n" {
+		t.Fatalf("got code %d, stdout %q; want 0, \"hi\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+
+	if !strings.Contains(stderr, `"span":"first-output"`) {
+		t.Errorf("no first-output span in stderr: %s", stderr)
+	}
+}
+
+func TestCommandPathWarning(t *testing.T) {
+	tests := []struct {
+		name       string
+		searchPath bool
+		warn       bool
+	}{
+		{"myapp", false, true},
+		{"/bin/myapp", false, false},
+		{"./myapp", false, false},
+		{"myapp", true, false},
+	}
+
+	for _, tt := range tests {
+		if got := commandPathWarning(tt.name, tt.searchPath); (got != "") != tt.warn {
+			t.Errorf("commandPathWarning(%q, %v) = %q; want warning %v", tt.name, tt.searchPath, got, tt.warn)
+		}
+	}
+}
+
+func TestCommandSearchesPath(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "echo", "hi")
+
+	if code != int(OK) || stdout != "hi\n" {
+		t.Fatalf("got code %d, stdout %q; want 0, \"hi\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+
+	if strings.Contains(stderr, "Warning: command") {
+		t.Errorf("unexpected warning: %s", stderr)
+	}
+
+	_, stderr, code = runMain(t, "", "--no-search-path", "--", "echo", "hi")
+
+	if code != int(InvalidCommand) || !strings.Contains(stderr, "Warning: command (echo)") {
+		t.Errorf("got code %d, stderr %q; want %d and a warning", code, stderr, InvalidCommand)
+	}
+}
+
+func TestWrapLooksUpWrapper(t *testing.T) {
+	for _, flags := range [][]string{{"--wrap", "env"}, {"--no-search-path", "--wrap", "env"}} {
+		stdout, stderr, code := runMain(t, "", append(flags, "--", "/bin/echo", "hi")...)
+
+		if code != int(OK) || stdout != "hi\n" {
+			t.Fatalf("%v: got code %d, stdout %q; want 0, \"hi\\n\"\nstderr: %s", flags, code, stdout, stderr)
+		}
+	}
+
+	stdout, stderr, code := runMain(t, "", "--wrap", "env", "--", "/bin/sh", "-c", `echo "$DRA_COMMAND"`)
+
+	if code != int(OK) || stdout != "/bin/sh\n" {
+		t.Errorf("got code %d, DRA_COMMAND %q; want 0, \"/bin/sh\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestEatFlag(t *testing.T) {
+	tests := []struct {
+		args       []string
+		flags      []string
+		paramCount int
+		params     ParamList
+		remaining  []string
+		err        FlagError
+	}{
+		{nil, []string{"-h", "--help"}, 0, ParamList{}, nil, FlagNotFound},
+		{[]string{"-h"}, []string{"-h", "--help"}, 0, ParamList{}, []string{}, FlagFound},
+		{[]string{"--help", "--", "app", "--help"}, []string{"-h", "--help"}, 0, ParamList{}, []string{"--", "app", "--help"}, FlagFound},
+		{[]string{"--", "app", "--help"}, []string{"-h", "--help"}, 0, ParamList{}, []string{"--", "app", "--help"}, FlagNotFound},
+		{[]string{"--"}, []string{"-h", "--help"}, 0, ParamList{}, []string{"--"}, FlagNotFound},
+		{[]string{"--chdir", "/tmp", "--", "--chdir", "/x"}, []string{"--chdir"}, 1, ParamList{"/tmp"}, []string{"--", "--chdir", "/x"}, FlagFound},
+		{[]string{"--chdir"}, []string{"--chdir"}, 1, ParamList{}, []string{"--chdir"}, FlagHasTooFewParams},
+		{[]string{"--chdir", "--", "app"}, []string{"--chdir"}, 1, ParamList{"--"}, []string{"--chdir", "--", "app"}, FlagParamIsFlag},
+		{[]string{"--chdir", "/a", "--chdir", "/b"}, []string{"--chdir"}, 1, ParamList{"/a"}, []string{"--chdir", "/b"}, FlagFound},
+	}
+
+	for _, tt := range tests {
+		params, remaining, err := eatFlag(tt.args, tt.flags, tt.paramCount)
+
+		if err != tt.err || !reflect.DeepEqual(params, tt.params) || !reflect.DeepEqual(remaining, tt.remaining) {
+			t.Errorf("eatFlag(%q, %q, %d) = %q, %q, %d; want %q, %q, %d", tt.args, tt.flags, tt.paramCount, params, remaining, err, tt.params, tt.remaining, tt.err)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args      []string
+		options   map[string]string
+		remaining []string
+	}{
+		{[]string{"--no-color", "--", "app", "--help"}, map[string]string{"no-color": "true", "start-delay": ""}, []string{"app", "--help"}},
+		{[]string{"--", "app", "--no-color"}, map[string]string{"start-delay": ""}, []string{"app", "--no-color"}},
+		{[]string{"--", "app", "--", "--version"}, map[string]string{"start-delay": ""}, []string{"app", "--", "--version"}},
+		{[]string{"--pass-fd", "3", "--pass-fd", "4", "--", "app"}, map[string]string{"pass-fd": "3\x004", "start-delay": ""}, []string{"app"}},
+		{[]string{"app", "arg"}, map[string]string{"start-delay": ""}, []string{"app", "arg"}},
+	}
+
+	// every case gets the --exec-delay alias.
+	for _, tt := range tests {
+		options, remaining := parseFlags(tt.args)
+
+		if !reflect.DeepEqual(options, tt.options) || !reflect.DeepEqual(remaining, tt.remaining) {
+			t.Errorf("parseFlags(%q) = %q, %q; want %q, %q", tt.args, options, remaining, tt.options, tt.remaining)
+		}
+	}
+}
+
+func TestParseFlagsExits(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   AppError
+		stdout string
+		stderr string
+	}{
+		{[]string{"--help"}, OK, "COMMAND", ""},
+		{[]string{"--", "/bin/sh", "-c", `echo "$@"`, "sh", "--help"}, OK, "--help\n", ""},
+		{[]string{"--", "/bin/sh", "-c", `echo "$@"`, "sh", "--", "-V"}, OK, "-- -V\n", ""},
+		{[]string{"--"}, MissingArgument, "", "missing <command>"},
+		{[]string{"--no-color", "--no-color", "--", "/bin/true"}, BadFlag, "", "flag --no-color cannot be given more than once"},
+		{[]string{"--bogus", "--", "/bin/true"}, BadFlag, "", "unknown flag (--bogus)"},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, "", tt.args...)
+
+		if code != int(tt.code) || !strings.Contains(stdout, tt.stdout) || !strings.Contains(stderr, tt.stderr) {
+			t.Errorf("%q: got code %d, stdout %q, stderr %q; want %d, %q, %q", tt.args, code, stdout, stderr, tt.code, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+// fakeProcess records what stopProcess sends it, and exits on the signal
+// stopOn, or never if it is nil.
+type fakeProcess struct {
+	mu      sync.Mutex
+	stopOn  os.Signal
+	signals []os.Signal
+	kills   int
+	exited  chan struct{}
+}
+
+func newFakeProcess(stopOn os.Signal) *fakeProcess {
+	return &fakeProcess{stopOn: stopOn, exited: make(chan struct{})}
+}
+
+func (p *fakeProcess) Signal(sig os.Signal) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.exited:
+		return os.ErrProcessDone
+	default:
+	}
+
+	p.signals = append(p.signals, sig)
+
+	if sig == p.stopOn {
+		close(p.exited)
+	}
+
+	return nil
+}
+
+func (p *fakeProcess) Kill() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.kills++
+
+	return nil
+}
+
+func TestStopProcess(t *testing.T) {
+	const timeout = 10 * time.Millisecond
+
+	steps := []StopStep{
+		{syscall.SIGINT, timeout},
+		{syscall.SIGTERM, timeout},
+		{syscall.SIGHUP, timeout},
+	}
+
+	tests := []struct {
+		name      string
+		stopOn    os.Signal
+		forceKill bool
+		sig       os.Signal
+		code      AppError
+		sent      int
+		kills     int
+	}{
+		{"first signal", syscall.SIGINT, true, syscall.SIGINT, OK, 1, 0},
+		{"last signal", syscall.SIGHUP, true, syscall.SIGHUP, OK, 3, 0},
+		{"ignored, killed", nil, true, nil, FailedToKillApp, 3, 1},
+	}
+
+	for _, tt := range tests {
+		p := newFakeProcess(tt.stopOn)
+
+		sig, code := stopProcess(p, p.exited, tt.forceKill, steps...)
+
+		if sig != tt.sig || code != tt.code || len(p.signals) != tt.sent || p.kills != tt.kills {
+			t.Errorf("%s: got %v, %d, %d sent, %d kills; want %v, %d, %d sent, %d kills", tt.name, sig, code, len(p.signals), p.kills, tt.sig, tt.code, tt.sent, tt.kills)
+		}
+	}
+}
+
+func TestStopProcessZeroTimeout(t *testing.T) {
+	// stable from run to run, however soon the app exits.
+	for i := 0; i < 100; i++ {
+		p := newFakeProcess(syscall.SIGKILL)
+
+		sig, code := stopProcess(p, p.exited, true, StopStep{syscall.SIGTERM, 0}, StopStep{syscall.SIGKILL, 0})
+
+		if sig != syscall.SIGKILL || code != FailedToKillApp || len(p.signals) != 2 || p.kills != 0 {
+			t.Fatalf("run %d: got %v, %d, %d sent, %d kills; want SIGKILL, %d, 2 sent, 0 kills", i, sig, code, len(p.signals), p.kills, FailedToKillApp)
+		}
+	}
+
+	// a step that stops the app, but with no time to see it, counts for nothing.
+	p := newFakeProcess(syscall.SIGTERM)
+
+	sig, code := stopProcess(p, p.exited, true, StopStep{syscall.SIGTERM, 0})
+
+	if sig != nil || code != FailedToKillApp || p.kills != 1 {
+		t.Errorf("SIGTERM:0: got %v, %d, %d kills; want nil, %d, 1 kill", sig, code, p.kills, FailedToKillApp)
+	}
+}
+
+func TestStopProcessExitedBeforeSignal(t *testing.T) {
+	p := newFakeProcess(nil)
+	close(p.exited)
+
+	sig, code := stopProcess(p, p.exited, true, StopStep{syscall.SIGTERM, time.Second})
+
+	if sig != syscall.SIGTERM || code != OK || p.kills != 0 {
+		t.Errorf("got %v, %d, %d kills; want SIGTERM, 0, 0 kills", sig, code, p.kills)
+	}
+}
+
+func TestStopProcessLongSignalList(t *testing.T) {
+	var steps []StopStep
+
+	for i := 0; i < 1000; i++ {
+		steps = append(steps, StopStep{syscall.SIGUSR1, 0})
+	}
+	steps = append(steps, StopStep{syscall.SIGUSR2, time.Second})
+
+	p := newFakeProcess(syscall.SIGUSR2)
+
+	sig, code := stopProcess(p, p.exited, true, steps...)
+
+	if sig != syscall.SIGUSR2 || code != OK || len(p.signals) != len(steps) || p.kills != 0 {
+		t.Errorf("got %v, %d, %d sent, %d kills; want SIGUSR2, 0, %d sent, 0 kills", sig, code, len(p.signals), p.kills, len(steps))
+	}
+}
+
+func TestStopProcessNoForceKill(t *testing.T) {
+	steps := []StopStep{{syscall.SIGTERM, 10 * time.Millisecond}, {syscall.SIGHUP, 10 * time.Millisecond}}
+
+	for _, stopOn := range []os.Signal{syscall.SIGTERM, nil} {
+		p := newFakeProcess(stopOn)
+
+		sig, code := stopProcess(p, p.exited, false, steps...)
+
+		want := OK
+		if stopOn == nil {
+			want = InsufficientSignalError
+		}
+
+		if sig != stopOn || code != want || p.kills != 0 {
+			t.Errorf("stop on %v: got %v, %d, %d kills; want %v, %d, 0 kills", stopOn, sig, code, p.kills, stopOn, want)
+		}
+	}
+
+	// a SIGKILL step, e.g. from --stop-signal-env, is not sent either.
+	p := newFakeProcess(syscall.SIGKILL)
+
+	sig, code := stopProcess(p, p.exited, false, StopStep{syscall.SIGKILL, 10 * time.Millisecond}, StopStep{syscall.SIGHUP, 10 * time.Millisecond})
+
+	if sig != nil || code != InsufficientSignalError || !reflect.DeepEqual(p.signals, []os.Signal{syscall.SIGHUP}) || p.kills != 0 {
+		t.Errorf("SIGKILL step: got %v, %d, sent %v, %d kills; want nil, %d, sent [hangup], 0 kills", sig, code, p.signals, p.kills, InsufficientSignalError)
+	}
+
+	_, stderr, exit := runMain(t, "", "--no-force-kill", "--stop-signals", "SIGTERM:5s,SIGKILL:0", "--", "/bin/true")
+	if exit != int(BadFlag) || !strings.Contains(stderr, "cannot send SIGKILL with --no-force-kill") {
+		t.Errorf("--stop-signals with SIGKILL: got code %d; want %d\nstderr: %s", exit, BadFlag, stderr)
+	}
+}
+
+func TestStartRetries(t *testing.T) {
+	defer func(start func(*exec.Cmd) error) { startCommand = start }(startCommand)
+
+	tests := []struct {
+		failures int
+		code     AppError
+		starts   int
+	}{
+		{0, OK, 1},
+		{2, OK, 3},
+		{3, CannotStartApp, 3},
+	}
+
+	for _, tt := range tests {
+		starts := 0
+
+		startCommand = func(cmd *exec.Cmd) error {
+			starts++
+			if starts <= tt.failures {
+				return syscall.EAGAIN
+			}
+			return cmd.Start()
+		}
+
+		options := map[string]string{"start-retries": "2", "start-retry-delay": "1ms"}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		code := runCommand(ctx, cancel, newCommand(ctx, "/bin/true", nil, false), nil, options)
+		cancel()
+
+		if code != tt.code || starts != tt.starts {
+			t.Errorf("%d failures: got code %d after %d starts; want %d after %d", tt.failures, code, starts, tt.code, tt.starts)
+		}
+	}
+}
+
+func TestHelpJSON(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--help-json")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var list []struct {
+		Name        string   `json:"name"`
+		Flags       []string `json:"flags"`
+		TakesParam  bool     `json:"takes_param"`
+		Param       string   `json:"param"`
+		Description string   `json:"description"`
+		Repeatable  bool     `json:"repeatable"`
+	}
+
+	if err := json.Unmarshal([]byte(stdout), &list); err != nil {
+		t.Fatalf("--help-json is not JSON: %v", err)
+	}
+
+	if len(list) != len(flagInfos) {
+		t.Fatalf("got %d flags; want %d", len(list), len(flagInfos))
+	}
+
+	for i, info := range flagInfos {
+		got := list[i]
+
+		if got.Name != info.Name || !reflect.DeepEqual(got.Flags, info.Flags) || got.TakesParam != (info.Param != "") || got.Param != info.Param || got.Description != info.Description || got.Repeatable != info.repeatable() {
+			t.Errorf("flag %d: got %+v; want %+v", i, got, info)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, flag := range list {
+		names[flag.Name] = true
+	}
+
+	for _, name := range []string{"help", "help-json", "version", "init-log"} {
+		if !names[name] {
+			t.Errorf("flag %s missing", name)
+		}
+	}
+}
+
+// errorJSON is what --error-format json writes for a fatal error.
+type errorJSON struct {
+	Code    AppError `json:"code"`
+	Message string   `json:"message"`
+}
+
+func TestErrorFormatJSONBadFlag(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--error-format", "json", "--bogus", "--", "/bin/true")
+
+	var got errorJSON
+	if err := json.Unmarshal([]byte(stderr), &got); err != nil {
+		t.Fatalf("stderr is not one JSON object: %v\nstderr: %s", err, stderr)
+	}
+
+	want := errorJSON{BadFlag, "unknown flag (--bogus)"}
+	if code != int(BadFlag) || got != want || stdout != "" {
+		t.Errorf("got code %d, %+v, stdout %q; want %d, %+v, no usage", code, got, stdout, BadFlag, want)
+	}
+}
+
+func TestErrorFormatJSONCannotStartApp(t *testing.T) {
+	var buf bytes.Buffer
+
+	defer func(format string, stderr *syncWriter, start func(*exec.Cmd) error) {
+		errorFormat, sharedStderr, startCommand = format, stderr, start
+	}(errorFormat, sharedStderr, startCommand)
+
+	errorFormat, sharedStderr = "json", newSyncWriter(&buf)
+	startCommand = func(*exec.Cmd) error { return syscall.ENOMEM }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	code := runCommand(ctx, cancel, newCommand(ctx, "/bin/true", nil, false), nil, map[string]string{})
+
+	var got errorJSON
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("error is not one JSON object: %v\n%s", err, buf.String())
+	}
+
+	want := errorJSON{CannotStartApp, "cannot start app (" + syscall.ENOMEM.Error() + ")"}
+	if code != CannotStartApp || got != want {
+		t.Errorf("got code %d, %+v; want %d, %+v", code, got, CannotStartApp, want)
+	}
+}
+
+func TestPassFd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	w.WriteString("hello")
+	w.Close()
+
+	// our fd 4, not 3, so it must be remapped for the app.
+	cmd := exec.Command(os.Args[0], "--pass-fd", "4", "--", "/bin/sh", "-c", `cat <&3; echo " $LISTEN_FDS $LISTEN_PID $$"`)
+	cmd.Env = append(os.Environ(), testMainEnv+"=1")
+	cmd.ExtraFiles = []*os.File{nil, r}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("got %v; want exit status 0", err)
+	}
+
+	// only the Linux exec helper sets LISTEN_PID.
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 || fields[0] != "hello" || fields[1] != "1" || runtime.GOOS == "linux" && (len(fields) != 4 || fields[2] != fields[3]) {
+		t.Errorf("got %q; want \"hello 1 PID PID\", PID the app's", out)
+	}
+}
+
+// mainRun is a docker-run-app started by startMain.
+type mainRun struct {
+	cmd    *exec.Cmd
+	stdout *bufio.Reader
+	stderr logBuffer
+}
+
+// logBuffer keeps what is written to it, for tests to read meanwhile.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// launchMain starts docker-run-app with args.
+func launchMain(t *testing.T, args ...string) *mainRun {
+	t.Helper()
+
+	r := &mainRun{cmd: exec.Command(os.Args[0], args...)}
+	r.cmd.Env = append(os.Environ(), testMainEnv+"=1")
+	r.cmd.Stderr = &r.stderr
+
+	stdout, err := r.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.cmd.Start(); err != nil {
+		t.Fatalf("cannot run docker-run-app: %v", err)
+	}
+
+	r.stdout = bufio.NewReader(stdout)
+
+	return r
+}
+
+// startMain
+//
+//  Start docker-run-app with args and wait for the app's first line of
+//  output, so the caller knows the app runs.
+//
+func startMain(t *testing.T, args ...string) *mainRun {
+	t.Helper()
+
+	r := launchMain(t, args...)
+
+	if _, err := r.stdout.ReadString('\n'); err != nil {
+		r.cmd.Wait()
+		t.Fatalf("app wrote nothing: %v\nstderr: %s", err, r.stderr.String())
+	}
+
+	return r
+}
+
+// waitLog waits for docker-run-app to log text.
+func (r *mainRun) waitLog(t *testing.T, text string) {
+	t.Helper()
+
+	for start := time.Now(); !strings.Contains(r.stderr.String(), text); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			r.cmd.Process.Kill()
+			t.Fatalf("docker-run-app did not log %q\nstderr: %s", text, r.stderr.String())
+		}
+	}
+}
+
+// signal sends docker-run-app sig.
+func (r *mainRun) signal(sig os.Signal) {
+	r.cmd.Process.Signal(sig)
+}
+
+// wait returns what docker-run-app wrote, less the first line of stdout, and
+// its exit code, once it exits.
+func (r *mainRun) wait() (stdout string, stderr string, code int) {
+	rest, _ := io.ReadAll(r.stdout)
+	r.cmd.Wait()
+
+	return string(rest), r.stderr.String(), r.cmd.ProcessState.ExitCode()
+}
+
+func TestExitCodes(t *testing.T) {
+	// the app ignores SIGTERM, and so will the sleep it becomes.
+	stubborn := []string{"--", "/bin/sh", "-c", `trap "" TERM; echo ready; exec sleep 2`}
+
+	tests := []struct {
+		code   AppError
+		args   []string
+		signal bool // send SIGTERM once the app writes a line
+	}{
+		{OK, []string{"--", "/bin/true"}, false},
+		{AppStoppedWithError, []string{"--", "/bin/false"}, false},
+		{FailedToKillApp, append([]string{"--stop-signals", "SIGTERM:50ms"}, stubborn...), true},
+		{MissingArgument, nil, false},
+		{InsufficientSignalError, append([]string{"--no-force-kill", "--stop-signals", "SIGTERM:50ms"}, stubborn...), true},
+		{InvalidCommand, []string{"--", "/nonexistent"}, false},
+		{BadFlag, []string{"--bogus", "--", "/bin/true"}, false},
+		{StderrMatched, []string{"--fail-on-stderr", "boom", "--", "/bin/sh", "-c", "echo boom >&2; exec sleep 5"}, false},
+		{PostStartFailed, []string{"--post-start", "/bin/false", "--post-start-required", "--", "/bin/sleep", "5"}, false},
+		{DeadlineExceeded, []string{"--deadline", "50ms", "--", "/bin/sleep", "5"}, false},
+	}
+
+	// CannotStartApp is in TestStartRetries, AlreadyRunning in TestLockFile.
+	for _, tt := range tests {
+		code := 0
+
+		if tt.signal {
+			r := startMain(t, tt.args...)
+			r.signal(syscall.SIGTERM)
+			_, _, code = r.wait()
+		} else {
+			_, _, code = runMain(t, "", tt.args...)
+		}
+
+		if code != int(tt.code) {
+			t.Errorf("%q: got code %d; want %d", tt.args, code, tt.code)
+		}
+	}
+}
+
+func TestFlagValueErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		message string
+	}{
+		{[]string{"--init-log"}, "flag --init-log needs a value"},
+		{[]string{"--init-log", "-x", "--", "/bin/true"}, "flag --init-log value cannot be a flag (-x)"},
+	}
+
+	for _, tt := range tests {
+		_, stderr, code := runMain(t, "", tt.args...)
+
+		if code != int(BadFlag) || !strings.Contains(stderr, "Error: "+tt.message+".") {
+			t.Errorf("%q: got code %d, stderr %q; want %d, %q", tt.args, code, stderr, BadFlag, tt.message)
+		}
+	}
+}
+
+func TestStopOnStdinClose(t *testing.T) {
+	input := strings.Repeat("\x00", 200000)
+
+	stdout, stderr, code := runMain(t, input, "--tag-streams", "--stop-on-stdin-close", "--", "/bin/cat")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var got strings.Builder
+
+	dec := json.NewDecoder(strings.NewReader(stdout))
+	for dec.More() {
+		var record struct {
+			Log string `json:"log"`
+		}
+
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("bad record: %v", err)
+		}
+
+		got.WriteString(record.Log)
+	}
+
+	if got.String() != input {
+		t.Errorf("app wrote %d bytes; want all %d", got.Len(), len(input))
+	}
+
+	if strings.Contains(stderr, "Attempting to stop app") {
+		t.Errorf("app that exits on end of input was signaled: %s", stderr)
+	}
+
+	// an app that does not read stdin is stopped after the grace period.
+	_, stderr, code = runMain(t, "x", "--stop-on-stdin-close", "--", "/bin/sleep", "10")
+
+	if code != int(OK) || !strings.Contains(stderr, "after stdin closed") {
+		t.Errorf("got code %d, stderr %q; want 0, stopped once stdin closed", code, stderr)
+	}
+}
+
+func TestExitNotHeldByOpenOutput(t *testing.T) {
+	// sleep outlives sh, and keeps its stderr open.
+	_, stderr, code := runMain(t, "", "--fail-on-stderr", "boom", "--", "/bin/sh", "-c", "sleep 3 >/dev/null & echo boom >&2; wait")
+
+	if code != int(StderrMatched) || !strings.Contains(stderr, "App exited") || strings.Contains(stderr, "still running") {
+		t.Errorf("got code %d, stderr %q; want %d, app exited on first signal", code, stderr, StderrMatched)
+	}
+
+	if !strings.Contains(stderr, "App output still open") {
+		t.Errorf("open output not reported: %s", stderr)
+	}
+}
+
+// writeScript writes a shell script to a file in dir and returns its path.
+func writeScript(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestStopPidFile(t *testing.T) {
+	dir := t.TempDir()
+	pidFile, stopped := filepath.Join(dir, "worker.pid"), filepath.Join(dir, "stopped")
+
+	// the worker writes its pid once it is ready for the signal.
+	worker := writeScript(t, dir, "worker", `trap 'echo stopped > "$1"; exit 0' TERM
+echo $$ > "$2"
+while :; do sleep 0.1; done
+`)
+	app := writeScript(t, dir, "app", `/bin/sh "$1" "$2" "$3" &
+while [ ! -s "$3" ]; do sleep 0.01; done
+echo ready
+wait
+`)
+
+	r := startMain(t, "--stop-pidfile", pidFile, "--", "/bin/sh", app, worker, stopped, pidFile)
+	r.signal(syscall.SIGTERM)
+
+	_, stderr, code := r.wait()
+	if code != int(OK) || !strings.Contains(stderr, "from pid file") {
+		t.Fatalf("got code %d, stderr %q; want 0, worker stopped", code, stderr)
+	}
+
+	if data, _ := os.ReadFile(stopped); string(data) != "stopped\n" {
+		t.Errorf("worker did not get the stop signal; wrote %q", data)
+	}
+}
+
+func TestStartDelaySignal(t *testing.T) {
+	r := launchMain(t, "--start-delay", "10s", "--", "/bin/echo", "started")
+	r.waitLog(t, "Delaying app start")
+	r.signal(syscall.SIGTERM)
+
+	stdout, stderr, code := r.wait()
+	if code != int(OK) || stdout != "" || strings.Contains(stderr, "App started") {
+		t.Errorf("got code %d, stdout %q, stderr %q; want 0 and no start", code, stdout, stderr)
+	}
+}
+
+func TestExecDelaySignal(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	r := launchMain(t, "--exec-delay", "300ms", "--", "/usr/bin/touch", marker)
+	r.waitLog(t, "Delaying app start")
+	r.signal(syscall.SIGINT)
+
+	if _, stderr, code := r.wait(); code != int(OK) || strings.Contains(stderr, "App started") {
+		t.Errorf("got code %d, stderr %q; want 0 and no start", code, stderr)
+	}
+
+	// past the delay, in case the app was started anyway.
+	time.Sleep(500 * time.Millisecond)
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("app ran after a signal during --exec-delay")
+	}
+}
+
+func TestPostStartSignal(t *testing.T) {
+	script := `trap 'echo usr1' USR1; i=0; while [ $i -lt 5 ]; do sleep 0.1; i=$((i+1)); done`
+
+	stdout, stderr, code := runMain(t, "", "--post-start-signal", "SIGUSR1", "--", "/bin/sh", "-c", script)
+
+	if code != int(OK) || stdout != "usr1\n" {
+		t.Errorf("got code %d, stdout %q; want 0, one usr1\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+// captureLog sends our log to a buffer until the test ends.
+func captureLog(t *testing.T) *logBuffer {
+	var buf logBuffer
+
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	return &buf
+}
+
+func TestDrainApp(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "drain.sock")
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	messages := make(chan string, 2)
+
+	// the first connection drains and closes, the second never answers.
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			messages <- line
+
+			if n == 0 {
+				conn.Close()
+			}
+		}
+	}()
+
+	logged := captureLog(t)
+
+	drainApp(socket, "drain now", time.Second)
+
+	if msg := <-messages; msg != "drain now\n" || !strings.Contains(logged.String(), "App drained.") {
+		t.Errorf("got message %q, log %q; want \"drain now\", drained", msg, logged.String())
+	}
+
+	start := time.Now()
+	drainApp(socket, "drain", 50*time.Millisecond)
+
+	if took := time.Since(start); took > time.Second || !strings.Contains(logged.String(), "App did not finish draining") {
+		t.Errorf("took %v, log %q; want a timeout after 50ms", took, logged.String())
+	}
+}
+
+func TestForwardAllSignals(t *testing.T) {
+	script := `trap 'echo usr1' USR1; trap 'echo usr2' USR2; trap 'echo hup' HUP; trap 'echo winch' WINCH
+echo ready
+while :; do sleep 0.05; done`
+
+	r := startMain(t, "--forward-all-signals", "--", "/bin/sh", "-c", script)
+
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP, syscall.SIGWINCH} {
+		r.signal(sig)
+
+		want := strings.ToLower(strings.TrimPrefix(signalName(sig), "SIG")) + "\n"
+		if line, _ := r.stdout.ReadString('\n'); line != want {
+			r.cmd.Process.Kill()
+			t.Fatalf("app got %q; want %q", line, want)
+		}
+	}
+
+	// SIGINT stops the app, rather than being forwarded as is.
+	r.signal(syscall.SIGINT)
+
+	if _, stderr, code := r.wait(); code != int(OK) || !strings.Contains(stderr, "Received signal (interrupt)") {
+		t.Errorf("got code %d, stderr %q; want 0, stopped on SIGINT", code, stderr)
+	}
+}
+
+func TestMergeStderr(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--merge-stderr", "--", "/bin/sh", "-c", "echo out; echo err >&2; echo out again")
+
+	if code != int(OK) || stdout != "out\nerr\nout again\n" || strings.Contains(stderr, "err\n") {
+		t.Errorf("got code %d, stdout %q, stderr %q; want 0, both streams in order on stdout", code, stdout, stderr)
+	}
+}
+
+func TestCwdFromCommand(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "where-am-i", "#!/bin/sh\npwd -P\n")
+
+	wd, _ := os.Getwd()
+	want, _ := filepath.EvalSymlinks(dir)
+
+	stdout, stderr, code := runMain(t, "", "--cwd-from-command", "--", filepath.Join(dir, "where-am-i"))
+	if code != int(OK) || stdout != want+"\n" {
+		t.Errorf("absolute path: got code %d, stdout %q; want 0, %q\nstderr: %s", code, stdout, want, stderr)
+	}
+
+	// a bare name found in PATH keeps our directory.
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	want, _ = filepath.EvalSymlinks(wd)
+
+	stdout, stderr, code = runMain(t, "", "--cwd-from-command", "--", "where-am-i")
+	if code != int(OK) || stdout != want+"\n" {
+		t.Errorf("name in PATH: got code %d, stdout %q; want 0, %q\nstderr: %s", code, stdout, want, stderr)
+	}
+}
+
+func TestInitLogTwice(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.log"), filepath.Join(dir, "second.log")
+
+	if _, stderr, code := runMain(t, "", "--init-log", first, "--init-log", second, "--", "/bin/true"); code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	for _, file := range []string{first, second} {
+		data, _ := os.ReadFile(file)
+
+		if !strings.Contains(string(data), "App started.") || !strings.Contains(string(data), "App finished") {
+			t.Errorf("%s lacks the lifecycle lines: %q", filepath.Base(file), data)
+		}
+	}
+}
+
+func TestPid1Warning(t *testing.T) {
+	if warning := pid1Warning(1); !strings.Contains(warning, "PID 1") {
+		t.Errorf("pid 1: got %q; want a warning", warning)
+	}
+
+	for _, pid := range []int{2, 42, os.Getpid()} {
+		if warning := pid1Warning(pid); warning != "" && pid != 1 {
+			t.Errorf("pid %d: got %q; want no warning", pid, warning)
+		}
+	}
+}
+
+func TestAllowNoCommand(t *testing.T) {
+	r := launchMain(t, "--allow-no-command")
+
+	r.waitLog(t, "No command given.")
+	r.signal(syscall.SIGTERM)
+
+	_, stderr, code := r.wait()
+	if code != int(OK) || strings.Contains(stderr, "missing <command>") {
+		t.Errorf("got code %d; want 0 and no missing command\nstderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Received signal (terminated). Exiting.") {
+		t.Errorf("no exit on SIGTERM logged in %q", stderr)
+	}
+}
+
+func TestAllocateBudget(t *testing.T) {
+	steps := []StopStep{{syscall.SIGTERM, 10 * time.Second}, {syscall.SIGHUP, 5 * time.Second}, {syscall.SIGKILL, 0}}
+
+	for _, budget := range []time.Duration{time.Millisecond, time.Second, 12 * time.Second, time.Minute} {
+		for _, rounds := range []int{1, 2} {
+			for _, drain := range []time.Duration{0, 10 * time.Second} {
+				gotDrain, gotSteps := allocateBudget(budget, drain, steps, rounds)
+
+				if total := shutdownTime(gotDrain, gotSteps, rounds); total > budget {
+					t.Errorf("budget %v, drain %v, %d rounds: shutdown takes %v", budget, drain, rounds, total)
+				}
+			}
+		}
+	}
+}
+
+func TestShutdownBudget(t *testing.T) {
+	const budget = 300 * time.Millisecond
+
+	// the app ignores every stop signal, so only the budget ends it.
+	r := startMain(t, "--shutdown-budget", budget.String(), "--", "/bin/sh", "-c", `trap "" INT TERM HUP; echo ready; exec sleep 10`)
+
+	start := time.Now()
+	r.signal(syscall.SIGTERM)
+	_, stderr, code := r.wait()
+
+	if took := time.Since(start); took > budget+time.Second {
+		t.Errorf("shutdown took %v; want about %v\nstderr: %s", took, budget, stderr)
+	}
+
+	if code != int(FailedToKillApp) {
+		t.Errorf("got code %d; want %d\nstderr: %s", code, FailedToKillApp, stderr)
+	}
+}
+
+func TestHoldOpenOnExit(t *testing.T) {
+	r := launchMain(t, "--hold-open-on-exit", "--", "/bin/sh", "-c", "exit 3")
+	r.waitLog(t, "Holding open after app exited (exit code 3).")
+
+	done := make(chan struct{})
+	go func() {
+		r.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("exited while holding open\nstderr: %s", r.stderr.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	r.signal(syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		r.cmd.Process.Kill()
+		t.Fatal("still holding open after SIGTERM")
+	}
+
+	if code := r.cmd.ProcessState.ExitCode(); code != int(AppStoppedWithError) {
+		t.Errorf("got code %d; want %d", code, AppStoppedWithError)
+	}
+}
+
+func TestExpandLogPath(t *testing.T) {
+	hostname, _ := os.Hostname()
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/var/log/app.log", "/var/log/app.log"},
+		{"/var/log/app-{date}-{pid}.log", "/var/log/app-2024-01-02-42.log"},
+		{"/var/log/{hostname}/app.log", "/var/log/" + hostname + "/app.log"},
+		{"/var/log/{pid}-{pid}-{unknown}.log", "/var/log/42-42-{unknown}.log"},
+	}
+
+	for _, tt := range tests {
+		if got := expandLogPath(tt.name, now, 42); got != tt.want {
+			t.Errorf("expandLogPath(%q) = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContextCancelKillsApp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := newCommand(ctx, "sleep", []string{"10"}, true)
+	clone := cloneCommand(ctx, app)
+
+	for _, cmd := range []*exec.Cmd{app, clone} {
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("cannot start %v: %v", cmd.Args, err)
+		}
+	}
+
+	cancel()
+
+	for _, cmd := range []*exec.Cmd{app, clone} {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			t.Fatalf("app (pid %d) still running after its context was canceled", cmd.Process.Pid)
+		}
+
+		status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		if !status.Signaled() || status.Signal() != syscall.SIGKILL {
+			t.Errorf("got %v; want the app killed", cmd.ProcessState)
+		}
+	}
+}
+
+func TestStderrLevel(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--tag-streams", "--stderr-level", "error", "--", "/bin/sh", "-c", "echo out; echo err >&2")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var record struct {
+		Stream string `json:"stream"`
+		Level  string `json:"level"`
+		Log    string `json:"log"`
+	}
+
+	if err := json.Unmarshal([]byte(stdout), &record); err != nil {
+		t.Fatalf("cannot parse stdout %q: %v", stdout, err)
+	}
+	if record.Stream != "stdout" || record.Level != "info" || record.Log != "out\n" {
+		t.Errorf("got stdout record %+v; want level info", record)
+	}
+
+	found := false
+
+	for _, line := range strings.Split(stderr, "\n") {
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		found = true
+
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("cannot parse stderr line %q: %v", line, err)
+		}
+		if record.Stream != "stderr" || record.Level != "error" || record.Log != "err\n" {
+			t.Errorf("got stderr record %+v; want level error", record)
+		}
+	}
+
+	if !found {
+		t.Errorf("no stderr record in %q", stderr)
+	}
+}
+
+func TestInitLogMkdir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	name := filepath.Join(dir, "init.log")
+
+	// without --init-log-mkdir, the log goes to stderr with a hint.
+	_, stderr, code := runMain(t, "", "--init-log", name, "--", "/bin/true")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+	if !strings.Contains(stderr, "does not exist. Use --init-log-mkdir") || !strings.Contains(stderr, "App started.") {
+		t.Errorf("want the missing directory warning and the log on stderr; got %q", stderr)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("directory was created without --init-log-mkdir (%v)", err)
+	}
+
+	_, stderr, code = runMain(t, "", "--init-log", name, "--init-log-mkdir", "--init-log-dir-mode", "0700", "--", "/bin/true")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("directory was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("got directory mode %v; want 0700", info.Mode().Perm())
+	}
+
+	if data, _ := os.ReadFile(name); !strings.Contains(string(data), "App started.") {
+		t.Errorf("log file lacks the lifecycle lines: %q", data)
+	}
+}
+
+func TestClearEnv(t *testing.T) {
+	t.Setenv("DRA_TEST_SECRET", "hunter2")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--clear-env", "--no-auto-env"}, []string{"HOME=" + os.Getenv("HOME"), "PATH=" + os.Getenv("PATH")}},
+		{[]string{"--clear-env-strict", "--no-auto-env"}, nil},
+		{[]string{"--clear-env-strict"}, []string{"DRA_COMMAND=/usr/bin/env", "DRA_STARTED_AT", "DRA_VERSION"}},
+	}
+
+	for _, tt := range tests {
+		stdout, stderr, code := runMain(t, "", append(tt.args, "--", "/usr/bin/env")...)
+		if code != int(OK) {
+			t.Fatalf("%v: got code %d; want 0\nstderr: %s", tt.args, code, stderr)
+		}
+
+		var got []string
+
+		for _, entry := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			// their values change from run to run.
+			if strings.HasPrefix(entry, "DRA_STARTED_AT=") || strings.HasPrefix(entry, "DRA_VERSION=") {
+				entry = entry[:strings.Index(entry, "=")]
+			}
+			if entry != "" {
+				got = append(got, entry)
+			}
+		}
+
+		sort.Strings(got)
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: app got environment %q; want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestTraceArgs(t *testing.T) {
+	argv := []string{"/bin/sh", "-c", "exit 0", "sh", "a b", "", `say "hi"`, "--"}
+
+	_, stderr, code := runMain(t, "", append([]string{"--trace-args", "--"}, argv...)...)
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var got []string
+
+	for _, m := range regexp.MustCompile(`Command argv\[(\d+)\] \((".*")\)\.`).FindAllStringSubmatch(stderr, -1) {
+		arg, err := strconv.Unquote(m[2])
+		if err != nil {
+			t.Fatalf("cannot unquote argv[%s] %s: %v", m[1], m[2], err)
+		}
+		if m[1] != strconv.Itoa(len(got)) {
+			t.Fatalf("got argv[%s]; want argv[%d]", m[1], len(got))
+		}
+		got = append(got, arg)
+	}
+
+	if !reflect.DeepEqual(got, argv) {
+		t.Errorf("traced argv %q; want %q", got, argv)
+	}
+	if !strings.Contains(stderr, `Command path ("/bin/sh").`) {
+		t.Errorf("no command path in %q", stderr)
+	}
+}
+
+func TestNoColorWhenPiped(t *testing.T) {
+	// started, stopped and an error, each of which is colored on a terminal.
+	stdout, stderr, code := runMain(t, "", "--", "/bin/sh", "-c", "echo hi; exit 3")
+	if code != int(AppStoppedWithError) {
+		t.Fatalf("got code %d; want %d\nstderr: %s", code, AppStoppedWithError, stderr)
+	}
+
+	if strings.Contains(stdout+stderr, "\x1b[") {
+		t.Errorf("got color codes with output piped\nstdout: %q\nstderr: %q", stdout, stderr)
+	}
+
+	// the same lines are colored when they do go to a terminal.
+	var colored bytes.Buffer
+	for _, line := range strings.SplitAfter(stderr, "\n") {
+		colorWriter{&colored}.Write([]byte(line))
+	}
+	if !strings.Contains(colored.String(), COLOR_GREEN) || !strings.Contains(colored.String(), COLOR_RED) {
+		t.Errorf("want green and red lines on a terminal; got %q", colored.String())
+	}
+}
+
+func TestParseStopSignalsNumeric(t *testing.T) {
+	steps, err := parseStopSignals("15:10s,SIGHUP:5s,int,9:0")
+	if err != nil {
+		t.Fatalf("parseStopSignals: %v", err)
+	}
+
+	want := []StopStep{{syscall.SIGTERM, 10 * time.Second}, {syscall.SIGHUP, 5 * time.Second}, {syscall.SIGINT, SIG_TIMEOUT}, {syscall.SIGKILL, 0}}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("got %v; want %v", steps, want)
+	}
+
+	for _, spec := range []string{"15,999", "SIGTERM,0", "-1", "SIGTERM,SIGBOGUS"} {
+		if _, err := parseStopSignals(spec); err == nil {
+			t.Errorf("%q: got no error; want unknown signal", spec)
+		}
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	env := []string{"HOME=/home/app", "NAME=web", "INDIRECT=${NAME}", "EMPTY=", "EQ=a=b"}
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"${HOME}/app", "/home/app/app"},
+		{"$HOME/$NAME.conf", "/home/app/web.conf"},
+		{"${HOME}${NAME}", "/home/appweb"},
+		{"--name=${NAME}-${NAME}", "--name=web-web"},
+		// values are not expanded again, as in a shell.
+		{"$INDIRECT", "${NAME}"},
+		{"${EQ}", "a=b"},
+		{"[${EMPTY}]", "[]"},
+		{"[${UNSET}]", "[]"},
+		{"[$UNSET/x]", "[/x]"},
+		{"no vars", "no vars"},
+	}
+
+	for _, tt := range tests {
+		got, err := expandArgs([]string{tt.arg}, env, false)
+		if err != nil || got[0] != tt.want {
+			t.Errorf("expandArgs(%q) = %q, %v; want %q", tt.arg, got, err, tt.want)
+		}
+	}
+
+	if _, err := expandArgs([]string{"${EMPTY}"}, env, true); err != nil {
+		t.Errorf("strict: an empty variable is set; got %v", err)
+	}
+	if _, err := expandArgs([]string{"$HOME", "${UNSET}/x"}, env, true); err == nil || !strings.Contains(err.Error(), "UNSET") {
+		t.Errorf("strict: got %v; want an error naming UNSET", err)
+	}
+}
+
+func TestCommandFromStdin(t *testing.T) {
+	stdin := `/bin/sh -c 'printf "[%s]\n" "$@"; cat' sh "a b" c` + "\nleft for app\n"
+
+	// the rest of stdin is forwarded to the app.
+	stdout, stderr, code := runMain(t, stdin, "--command-from-stdin", "--stop-on-stdin-close")
+	if want := "[a b]\n[c]\nleft for app\n"; code != int(OK) || stdout != want {
+		t.Errorf("got code %d, stdout %q; want 0, %q\nstderr: %s", code, stdout, want, stderr)
+	}
+
+	// a COMMAND on the command line wins, and all of stdin stays the app's.
+	stdout, stderr, code = runMain(t, "/bin/false\n", "--command-from-stdin", "--stop-on-stdin-close", "--", "/bin/cat")
+	if code != int(OK) || stdout != "/bin/false\n" {
+		t.Errorf("got code %d, stdout %q; want 0, \"/bin/false\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+
+	for _, stdin := range []string{"", "\n"} {
+		if _, stderr, code := runMain(t, stdin, "--command-from-stdin"); code != int(MissingArgument) {
+			t.Errorf("stdin %q: got code %d; want %d\nstderr: %s", stdin, code, MissingArgument, stderr)
+		}
+	}
+}
+
+func TestStopExitDetail(t *testing.T) {
+	loop := `echo ready; while :; do sleep 0.1; done`
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+		code AppError
+	}{
+		{"clean", []string{"--", "/bin/sh", "-c", `trap "exit 0" TERM; ` + loop}, "App stopped with signal (terminated), exited cleanly (code 0).", OK},
+		{"error", []string{"--", "/bin/sh", "-c", `trap "exit 5" TERM; ` + loop}, "App stopped with signal (terminated), exited with code (5).", AppStoppedWithError},
+		{"escalated", []string{"--stop-signals", "SIGTERM:200ms,SIGQUIT:5s", "--", "/bin/sh", "-c", `trap "" TERM; ` + loop}, "App stopped with signal (quit), terminated by signal (quit).", InsufficientSignalError},
+		{"killed by step", []string{"--stop-signals", "SIGTERM:200ms,SIGKILL:5s", "--", "/bin/sh", "-c", `trap "" TERM; ` + loop}, "App killed, terminated by signal (killed).", FailedToKillApp},
+		{"force killed", []string{"--stop-signals", "SIGTERM:200ms", "--", "/bin/sh", "-c", `trap "" TERM; ` + loop}, "App killed, terminated by signal (killed).", FailedToKillApp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := startMain(t, tt.args...)
+			r.signal(syscall.SIGTERM)
+
+			_, stderr, code := r.wait()
+			if code != int(tt.code) || !strings.Contains(stderr, tt.want) {
+				t.Errorf("got code %d; want %d and %q\nstderr: %s", code, tt.code, tt.want, stderr)
+			}
+		})
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(config, []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := startMain(t, "--watch-file", config, "--", "/bin/sh", "-c", `trap 'echo hup' HUP; echo ready; while :; do sleep 0.1; done`)
+
+	// a burst of writes, as an editor or a config map update makes.
+	for _, data := range []string{"ab\n", "abc\n", "abcd\n"} {
+		if err := os.WriteFile(config, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	r.waitLog(t, "Watched file changed")
+
+	// long enough for a second, unwanted, reload.
+	time.Sleep(WATCH_INTERVAL + WATCH_INTERVAL/2)
+	r.signal(syscall.SIGTERM)
+
+	stdout, stderr, code := r.wait()
+	if code != int(OK) || stdout != "hup\n" || strings.Count(stderr, "Watched file changed") != 1 {
+		t.Errorf("got code %d, stdout %q; want 0 and one reload\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestAutoEnv(t *testing.T) {
+	path, err := exec.LookPath("env")
+	if err != nil {
+		t.Skip("no env in PATH")
+	}
+
+	before := time.Now().Truncate(time.Second)
+
+	stdout, stderr, code := runMain(t, "", "--", "env")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	vars := map[string]string{}
+	for _, entry := range strings.Split(stdout, "\n") {
+		if name, value, found := strings.Cut(entry, "="); found && strings.HasPrefix(name, "DRA_") {
+			vars[name] = value
+		}
+	}
+
+	if vars["DRA_VERSION"] != VERSION {
+		t.Errorf("got DRA_VERSION %q; want %q", vars["DRA_VERSION"], VERSION)
+	}
+	if vars["DRA_COMMAND"] != path {
+		t.Errorf("got DRA_COMMAND %q; want the resolved %q", vars["DRA_COMMAND"], path)
+	}
+
+	started, err := time.Parse(time.RFC3339, vars["DRA_STARTED_AT"])
+	if err != nil || started.Before(before) || started.After(time.Now()) {
+		t.Errorf("got DRA_STARTED_AT %q (%v); want RFC 3339 time of this run", vars["DRA_STARTED_AT"], err)
+	}
+
+	stdout, _, _ = runMain(t, "", "--no-auto-env", "--", "env")
+	if strings.Contains(stdout, "DRA_") {
+		t.Errorf("got DRA_ variables with --no-auto-env: %q", stdout)
+	}
+}
+
+func TestInitLogDestinations(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "init.log")
+
+	stdout, stderr, code := runMain(t, "", "--init-log", "stdout", "--", "/bin/echo", "hi")
+	if code != int(OK) || !strings.Contains(stdout, "App started.") || !strings.Contains(stdout, "hi\n") || strings.Contains(stderr, "App started.") {
+		t.Errorf("stdout: got code %d, stdout %q, stderr %q; want the log on stdout only", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runMain(t, "", "--init-log", "stderr", "--", "/bin/echo", "hi")
+	if code != int(OK) || stdout != "hi\n" || !strings.Contains(stderr, "App started.") {
+		t.Errorf("stderr: got code %d, stdout %q, stderr %q; want the log on stderr only", code, stdout, stderr)
+	}
+
+	stdout, stderr, code = runMain(t, "", "--init-log", file, "--", "/bin/echo", "hi")
+	data, _ := os.ReadFile(file)
+	if code != int(OK) || stdout != "hi\n" || strings.Contains(stderr, "App started.") || !strings.Contains(string(data), "App started.") {
+		t.Errorf("file: got code %d, stdout %q, stderr %q, file %q; want the log in the file only", code, stdout, stderr, data)
+	}
+}
+
+func TestTolerateEscalation(t *testing.T) {
+	app := []string{"--", "/bin/sh", "-c", `trap "" TERM; echo ready; while :; do sleep 0.1; done`}
+
+	tests := []struct {
+		name string
+		args []string
+		code AppError
+	}{
+		{"strict escalated", []string{"--stop-signals", "SIGTERM:200ms,SIGQUIT:5s"}, InsufficientSignalError},
+		{"strict killed by step", []string{"--stop-signals", "SIGTERM:200ms,SIGKILL:5s"}, FailedToKillApp},
+		{"strict killed", []string{"--stop-signals", "SIGTERM:200ms"}, FailedToKillApp},
+		{"tolerant escalated", []string{"--tolerate-escalation", "--stop-signals", "SIGTERM:200ms,SIGQUIT:5s"}, OK},
+		{"tolerant killed by step", []string{"--tolerate-escalation", "--stop-signals", "SIGTERM:200ms,SIGKILL:5s"}, OK},
+		{"tolerant killed", []string{"--tolerate-escalation", "--stop-signals", "SIGTERM:200ms"}, OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := startMain(t, append(tt.args, app...)...)
+			r.signal(syscall.SIGTERM)
+
+			if _, stderr, code := r.wait(); code != int(tt.code) {
+				t.Errorf("got code %d; want %d\nstderr: %s", code, tt.code, stderr)
+			}
+		})
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"run", []string{"--deadline", "300ms", "--", "/bin/sh", "-c", `trap "exit 0" TERM; while :; do sleep 0.1; done`}},
+		{"start delay", []string{"--deadline", "300ms", "--start-delay", "10s", "--", "/usr/bin/touch", marker}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+
+			_, stderr, code := runMain(t, "", tt.args...)
+			if code != int(DeadlineExceeded) || !strings.Contains(stderr, "Deadline passed (300ms).") {
+				t.Errorf("got code %d; want %d\nstderr: %s", code, DeadlineExceeded, stderr)
+			}
+			if took := time.Since(start); took > 3*time.Second {
+				t.Errorf("took %v to exit after a 300ms deadline", took)
+			}
+		})
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("app ran after the deadline passed during --start-delay")
+	}
+
+	// a shutdown that would outlast the deadline is cut short.
+	r := startMain(t, "--deadline", "500ms", "--stop-signals", "SIGTERM:10s", "--", "/bin/sh", "-c", `trap "" TERM; echo ready; while :; do sleep 0.1; done`)
+	r.signal(syscall.SIGTERM)
+
+	start := time.Now()
+
+	_, stderr, code := r.wait()
+	if code != int(DeadlineExceeded) || !strings.Contains(stderr, "Killing app.") {
+		t.Errorf("shutdown: got code %d; want %d and the app killed\nstderr: %s", code, DeadlineExceeded, stderr)
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("shutdown: took %v to exit after a 500ms deadline", took)
+	}
+}
+
+func TestPostStop(t *testing.T) {
+	hook := `echo "post-stop $DRA_STOP_SIGNAL code=$DRA_EXIT_CODE signal=$DRA_EXIT_SIGNAL"`
+
+	r := startMain(t, "--post-stop", hook, "--", "/bin/sh", "-c", `trap "exit 3" TERM; echo ready; while :; do sleep 0.1; done`)
+	r.signal(syscall.SIGTERM)
+
+	stdout, stderr, _ := r.wait()
+	if want := "post-stop SIGTERM code=3 signal=\n"; stdout != want {
+		t.Errorf("signal: got stdout %q; want %q\nstderr: %s", stdout, want, stderr)
+	}
+
+	// the app stopping on its own is not a shutdown.
+	stdout, stderr, code := runMain(t, "", "--post-stop", hook, "--", "/bin/echo", "hi")
+	if code != int(OK) || stdout != "hi\n" || strings.Contains(stderr, "post-stop hook") {
+		t.Errorf("clean exit: got code %d, stdout %q; want 0, no hook\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestCountOutput(t *testing.T) {
+	app := []string{"--", "/bin/sh", "-c", `printf "a\nbb\n"; printf "ccc\nno newline" >&2`}
+
+	_, stderr, code := runMain(t, "", append([]string{"--count-output"}, app...)...)
+	if want := "App output (stdout 5 bytes in 2 lines, stderr 14 bytes in 1 lines)."; code != int(OK) || !strings.Contains(stderr, want) {
+		t.Errorf("got code %d; want 0 and %q\nstderr: %s", code, want, stderr)
+	}
+
+	_, stderr, code = runMain(t, "", append([]string{"--count-output", "--merge-stderr"}, app...)...)
+	if want := "App output (stdout and stderr merged, 19 bytes in 3 lines)."; code != int(OK) || !strings.Contains(stderr, want) {
+		t.Errorf("merged: got code %d; want 0 and %q\nstderr: %s", code, want, stderr)
+	}
+}
+
+func TestValidateJSONStdout(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--validate-json-stdout", "--", "/bin/sh", "-c", `printf '{"level": "info"}\nnot json\n'`)
+
+	// the output passes through as is, and only the bad line is warned about.
+	if code != int(OK) || stdout != "{\"level\": \"info\"}\nnot json\n" {
+		t.Errorf("got code %d, stdout %q; want 0 and both lines\nstderr: %s", code, stdout, stderr)
+	}
+	if want := `Warning: app's stdout line 2 is not JSON ("not json").`; !strings.Contains(stderr, want) || strings.Contains(stderr, "line 1 is not JSON") {
+		t.Errorf("want only %q in stderr: %s", want, stderr)
+	}
+}
+
+func TestShutdownExitCode(t *testing.T) {
+	app := []string{"--", "/bin/sh", "-c", `trap "exit 3" TERM; echo ready; while :; do sleep 0.1; done`}
+
+	tests := []struct {
+		name string
+		args []string
+		code AppError
+	}{
+		{"respected", app, AppStoppedWithError},
+		{"ignored", append([]string{"--ignore-shutdown-exit-code"}, app...), OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := startMain(t, tt.args...)
+			r.signal(syscall.SIGTERM)
+
+			if _, stderr, code := r.wait(); code != int(tt.code) {
+				t.Errorf("got code %d; want %d\nstderr: %s", code, tt.code, stderr)
+			}
+		})
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	r := startMain(t, "--heartbeat-interval", "100ms", "--", "/bin/sh", "-c", `trap "exit 0" TERM; echo ready; while :; do sleep 0.05; done`)
+
+	time.Sleep(550 * time.Millisecond)
+	r.signal(syscall.SIGTERM)
+
+	_, stderr, code := r.wait()
+
+	// none after the shutdown began.
+	running, _, _ := strings.Cut(stderr, "Received signal")
+	beats := strings.Count(running, "App still running (pid ")
+
+	if code != int(OK) || beats < 3 || beats > 6 || strings.Count(stderr, "App still running (pid ") != beats {
+		t.Errorf("got code %d and %d heartbeats in 550ms; want 0 and about 5\nstderr: %s", code, beats, stderr)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	dir := t.TempDir()
+	script := writeScript(t, dir, "app.sh", "exit 0")
+
+	plain := filepath.Join(dir, "plain.txt")
+	if err := os.WriteFile(plain, []byte("text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{script, ""},
+		{filepath.Join(dir, "missing"), "not found"},
+		{dir, "is a directory"},
+		{plain, "is not executable"},
+		{"docker-run-app-no-such-command", "not found in PATH"},
+	}
+
+	for _, tt := range tests {
+		err := checkCommand(exec.Command(tt.name), "")
+
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%s: got %v; want no error", tt.name, err)
+		case tt.want != "" && (!errors.Is(err, errBadCommand) || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v; want %q", tt.name, err, tt.want)
+		}
+	}
+
+	_, stderr, code := runMain(t, "", "--fail-fast", "--", plain)
+	if code != int(InvalidCommand) || !strings.Contains(stderr, "is not executable") {
+		t.Errorf("got code %d; want %d and the reason\nstderr: %s", code, InvalidCommand, stderr)
+	}
+}
+
+func TestSignalMap(t *testing.T) {
+	script := `trap 'echo quit; exit 0' QUIT; trap 'echo term; exit 0' TERM; trap 'echo usr1' USR1; echo ready; while :; do sleep 0.05; done`
+
+	r := startMain(t, "--signal-map", "SIGTERM=SIGQUIT,SIGHUP=SIGUSR1", "--", "/bin/sh", "-c", script)
+
+	r.signal(syscall.SIGHUP)
+
+	if line, err := r.stdout.ReadString('\n'); line != "usr1\n" {
+		t.Fatalf("got %q (%v); want usr1 for SIGHUP", line, err)
+	}
+
+	r.signal(syscall.SIGTERM)
+
+	stdout, stderr, code := r.wait()
+	if code != int(OK) || stdout != "quit\n" {
+		t.Errorf("got code %d, stdout %q; want 0, quit for SIGTERM\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestStopSignalEnv(t *testing.T) {
+	script := `trap 'echo quit; exit 0' QUIT; trap 'echo term; exit 0' TERM; trap 'echo usr2; exit 0' USR2; echo ready; while :; do sleep 0.05; done`
+
+	tests := []struct {
+		value string
+		flags []string
+		want  string
+	}{
+		{"SIGQUIT", nil, "quit\n"},
+		// unset, the signal received comes first, or the first step of --stop-signals.
+		{"", nil, "term\n"},
+		{"", []string{"--stop-signals", "SIGUSR2:2s,SIGTERM"}, "usr2\n"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("APP_STOP_SIGNAL", tt.value)
+		if tt.value == "" {
+			os.Unsetenv("APP_STOP_SIGNAL")
+		}
+
+		args := append([]string{"--stop-signal-env", "APP_STOP_SIGNAL"}, tt.flags...)
+		r := startMain(t, append(args, "--", "/bin/sh", "-c", script)...)
+
+		r.signal(syscall.SIGTERM)
+
+		stdout, stderr, code := r.wait()
+		if code != int(OK) || stdout != tt.want {
+			t.Errorf("%q %v: got code %d, stdout %q; want 0, %q\nstderr: %s", tt.value, tt.flags, code, stdout, tt.want, stderr)
+		}
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(config, []byte(`{"drain-timeout": "3s", "stop-signals": "SIGTERM:5s", "command": ["/bin/true"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the flag wins over --json-config.
+	_, stderr, code := runMain(t, "", "--json-config", config, "--print-config", "--stop-signals", "SIGINT:1s")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	for _, want := range []string{`command=["/bin/true"]`, `drain-timeout="3s"`, `stop-signals="SIGINT:1s"`, `; defaults: `, `post-stop-timeout="10s"`} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("config record lacks %s\nstderr: %s", want, stderr)
+		}
+	}
+
+	_, stderr, code = runMain(t, "", "--json-config", config, "--print-config", "--error-format", "json")
+	if code != int(OK) {
+		t.Fatalf("json: got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var record struct {
+		Config   map[string]interface{} `json:"config"`
+		Defaults map[string]interface{} `json:"defaults"`
+	}
+
+	line, _, _ := strings.Cut(stderr, "\n")
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("json: cannot parse the first line %q: %v", line, err)
+	}
+
+	if record.Config["stop-signals"] != "SIGTERM:5s" || record.Config["print-config"] != true || record.Defaults["signal-buffer"] != "1" {
+		t.Errorf("json: got %+v; want the resolved config and defaults", record)
+	}
+	if _, found := record.Defaults["drain-timeout"]; found {
+		t.Errorf("json: drain-timeout is set, yet listed among the defaults")
+	}
+}
+
+func TestIgnoreSigpipe(t *testing.T) {
+	script := `echo first; i=0; while [ $i -lt 20 ]; do echo more; sleep 0.02; i=$((i+1)); done; echo survived >&2`
+
+	for _, ignore := range []bool{true, false} {
+		args := []string{"--", "/bin/sh", "-c", script}
+		if ignore {
+			args = append([]string{"--ignore-sigpipe"}, args...)
+		}
+
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stderr logBuffer
+
+		cmd := exec.Command(os.Args[0], args...)
+		cmd.Env = append(os.Environ(), testMainEnv+"=1")
+		cmd.Stdout, cmd.Stderr = writer, &stderr
+
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		writer.Close()
+
+		// the consumer goes away after one line.
+		bufio.NewReader(reader).ReadString('\n')
+		reader.Close()
+
+		cmd.Wait()
+
+		survived := strings.Contains(stderr.String(), "survived")
+		if ignore && (cmd.ProcessState.ExitCode() != int(OK) || !survived) {
+			t.Errorf("ignoring: got code %d; want 0 and the app to finish\nstderr: %s", cmd.ProcessState.ExitCode(), stderr.String())
+		}
+		if !ignore && survived {
+			t.Errorf("not ignoring: the app finished; want it stopped by SIGPIPE\nstderr: %s", stderr.String())
+		}
+	}
+}
+
+func TestSignalBuffer(t *testing.T) {
+	script := `for sig in USR1 USR2 HUP ALRM; do trap "echo $sig" $sig; done; trap "exit 0" TERM; echo ready; while :; do sleep 0.05; done`
+
+	r := startMain(t, "--forward-all-signals", "--signal-buffer", "8", "--", "/bin/sh", "-c", script)
+
+	// a burst, faster than they are forwarded one by one.
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP, syscall.SIGALRM} {
+		r.signal(sig)
+	}
+
+	got := make(chan []string, 1)
+
+	go func() {
+		var lines []string
+		for len(lines) < 4 {
+			line, err := r.stdout.ReadString('\n')
+			if err != nil {
+				break
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		got <- lines
+	}()
+
+	var lines []string
+
+	select {
+	case lines = <-got:
+	case <-time.After(5 * time.Second):
+	}
+
+	r.signal(syscall.SIGTERM)
+	_, stderr, _ := r.wait()
+
+	sort.Strings(lines)
+	if want := []string{"ALRM", "HUP", "USR1", "USR2"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("app got signals %q; want %q\nstderr: %s", lines, want, stderr)
+	}
+}
+
+func TestMinRuntime(t *testing.T) {
+	_, stderr, code := runMain(t, "", "--min-runtime", "2s", "--", "/bin/true")
+	if code != int(OK) || !strings.Contains(stderr, "less than --min-runtime (2s)") {
+		t.Errorf("fast: got code %d; want 0 and a warning\nstderr: %s", code, stderr)
+	}
+
+	_, stderr, code = runMain(t, "", "--min-runtime", "100ms", "--", "/bin/sleep", "0.3")
+	if code != int(OK) || strings.Contains(stderr, "--min-runtime") {
+		t.Errorf("slow: got code %d; want 0 and no warning\nstderr: %s", code, stderr)
+	}
+
+	// stopping it was our doing, not a crash.
+	r := startMain(t, "--min-runtime", "10s", "--", "/bin/sh", "-c", `trap "exit 0" TERM; echo ready; while :; do sleep 0.05; done`)
+	r.signal(syscall.SIGTERM)
+
+	if _, stderr, code := r.wait(); code != int(OK) || strings.Contains(stderr, "--min-runtime") {
+		t.Errorf("stopped: got code %d; want 0 and no warning\nstderr: %s", code, stderr)
+	}
+}
+
+func TestLogInvocationMasksSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("api_token", "abc123")
+	t.Setenv("DRA_TEST_PLAIN", "visible")
+
+	_, stderr, code := runMain(t, "", "--log-invocation", "--error-format", "json", "--", "/bin/true", "arg")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	var record struct {
+		Invocation struct {
+			Path    string                 `json:"path"`
+			Argv    []string               `json:"argv"`
+			Options map[string]interface{} `json:"options"`
+			Env     []string               `json:"env"`
+		} `json:"invocation"`
+	}
+
+	line, _, _ := strings.Cut(stderr, "\n")
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("cannot parse the first line %q: %v", line, err)
+	}
+
+	if strings.Contains(stderr, "hunter2") || strings.Contains(stderr, "abc123") {
+		t.Errorf("a secret value was logged\nstderr: %s", stderr)
+	}
+
+	env := strings.Join(record.Invocation.Env, "\n")
+	for _, want := range []string{"DB_PASSWORD=***", "api_token=***", "DRA_TEST_PLAIN=visible"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("env lacks %s: %q", want, record.Invocation.Env)
+		}
+	}
+
+	if record.Invocation.Path != "/bin/true" || !reflect.DeepEqual(record.Invocation.Argv, []string{"/bin/true", "arg"}) || record.Invocation.Options["log-invocation"] != true {
+		t.Errorf("got %+v; want the resolved command and options", record.Invocation)
+	}
+}
+
+func TestKillExitCode(t *testing.T) {
+	app := []string{"--", "/bin/sh", "-c", `trap "" TERM; echo ready; while :; do sleep 0.05; done`}
+	stubborn := append([]string{"--stop-signals", "SIGTERM:200ms"}, app...)
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"default", stubborn, int(FailedToKillApp)},
+		{"custom", append([]string{"--kill-exit-code", "137"}, stubborn...), 137},
+		{"custom, killed by step", append([]string{"--kill-exit-code", "137", "--stop-signals", "SIGTERM:200ms,SIGKILL:1s"}, app...), 137},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := startMain(t, tt.args...)
+			r.signal(syscall.SIGTERM)
+
+			if _, stderr, code := r.wait(); code != tt.code || !strings.Contains(stderr, "App finished (killed after timeout") {
+				t.Errorf("got code %d; want %d and a kill\nstderr: %s", code, tt.code, stderr)
+			}
+		})
+	}
+
+	// an app that stops on the signal keeps its usual code.
+	r := startMain(t, "--kill-exit-code", "137", "--", "/bin/sh", "-c", `trap "exit 0" TERM; echo ready; while :; do sleep 0.05; done`)
+	r.signal(syscall.SIGTERM)
+
+	if _, stderr, code := r.wait(); code != int(OK) {
+		t.Errorf("stopped: got code %d; want 0\nstderr: %s", code, stderr)
+	}
+}
@@ -0,0 +1,435 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MAX_LINE = 64 * 1024 // longest line a lineWriter buffers before splitting it, unless --max-line
+)
+
+// sharedStderr is our stderr, shared by the log and the app's stderr when we
+// copy it.  Each Write goes out whole, so their lines cannot tear each other.
+var sharedStderr = newSyncWriter(os.Stderr)
+
+const (
+	COLOR_RESET  = "\x1b[0m"
+	COLOR_RED    = "\x1b[31m"
+	COLOR_GREEN  = "\x1b[32m"
+	COLOR_YELLOW = "\x1b[33m"
+)
+
+// isTerminal
+//
+//  Report whether f looks like a terminal: a character device that is not
+//  /dev/null.
+//
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+
+	return err != nil || !os.SameFile(info, null)
+}
+
+// colorWriter
+//
+//  Color each log line written to it by what it reports: errors red, signals
+//  and warnings yellow, the app starting and stopping green.  Meant for our
+//  own log only, one line per Write, as log.Logger does.
+//
+type colorWriter struct {
+	w io.Writer
+}
+
+func (w colorWriter) Write(p []byte) (int, error) {
+	line := string(p)
+
+	color := logColor(line)
+	if color == "" {
+		return w.w.Write(p)
+	}
+
+	if _, err := io.WriteString(w.w, color+strings.TrimSuffix(line, "\n")+COLOR_RESET+"\n"); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// logColor picks the color of a log line, "" for none.
+func logColor(line string) string {
+	switch {
+	case strings.Contains(line, "Error:"), strings.Contains(line, "Cannot "), strings.Contains(line, "with error"), strings.Contains(line, "ignored stop signals"), strings.Contains(line, "killed"):
+		return COLOR_RED
+	case strings.Contains(line, "Warning:"), strings.Contains(line, "signal ("):
+		return COLOR_YELLOW
+	case strings.Contains(line, "App started."), strings.Contains(line, "App stopped."), strings.Contains(line, "App finished (normal exit, exit status 0)"), strings.Contains(line, "App finished (stopped by signal"):
+		return COLOR_GREEN
+	default:
+		return ""
+	}
+}
+
+// levelColorWriter
+//
+//  Color each line of the app's output written to it by the log level it
+//  starts with, e.g. "ERROR ...", "[WARN] ..." or "info: ...".  Lines without
+//  a level pass as is.  One line per Write, as lineWriter hands them out.
+//
+type levelColorWriter struct {
+	w io.Writer
+}
+
+func (w levelColorWriter) Write(p []byte) (int, error) {
+	color := levelColor(p)
+	if color == "" {
+		return w.w.Write(p)
+	}
+
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	colored := append(append([]byte(color), line...), COLOR_RESET...)
+	if len(line) < len(p) {
+		colored = append(colored, '\n')
+	}
+
+	if _, err := w.w.Write(colored); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// levelColor picks the color for a line by the level token it starts with.
+func levelColor(line []byte) string {
+	line = bytes.TrimLeft(line, " \t[")
+
+	// one letter more than the longest level, so longer words do not match.
+	end := 0
+	for end < len(line) && end < len("CRITICAL")+1 && isLetter(line[end]) {
+		end++
+	}
+
+	switch strings.ToUpper(string(line[:end])) {
+	case "ERROR", "ERR", "FATAL", "PANIC", "CRIT", "CRITICAL":
+		return COLOR_RED
+	case "WARN", "WARNING":
+		return COLOR_YELLOW
+	case "INFO":
+		return COLOR_GREEN
+	default:
+		return ""
+	}
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// syncWriter
+//
+//  Serialize writes to w.  Write each line in one call to keep it whole.
+//
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newSyncWriter(w io.Writer) *syncWriter {
+	return &syncWriter{w: w}
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p)
+}
+
+// lineWriter
+//
+//  Split the bytes written to it into lines and pass each line, including its
+//  newline, to handle.  The line is only valid during the call.  Lines longer
+//  than max are passed on in max sized pieces, so a line without end cannot use
+//  up memory.  Call Flush once writing is done to handle a last line without a
+//  newline.
+//
+type lineWriter struct {
+	mu     sync.Mutex
+	buf    []byte
+	max    int
+	handle func(line []byte)
+}
+
+func newLineWriter(max int, handle func(line []byte)) *lineWriter {
+	return &lineWriter{max: max, handle: handle}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+
+		if i < 0 {
+			// no line end yet. keep what fits, pass on full pieces.
+			room := w.max - len(w.buf)
+			if len(p) < room {
+				w.buf = append(w.buf, p...)
+				break
+			}
+
+			w.buf = append(w.buf, p[:room]...)
+			p = p[room:]
+			w.emit()
+			continue
+		}
+
+		if len(w.buf)+i+1 > w.max {
+			room := w.max - len(w.buf)
+			w.buf = append(w.buf, p[:room]...)
+			p = p[room:]
+			w.emit()
+			continue
+		}
+
+		w.buf = append(w.buf, p[:i+1]...)
+		p = p[i+1:]
+		w.emit()
+	}
+
+	return n, nil
+}
+
+// Flush handles any partial line left in the buffer.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit()
+	}
+}
+
+func (w *lineWriter) emit() {
+	w.handle(w.buf)
+	w.buf = w.buf[:0]
+}
+
+// streamTagger
+//
+//  Write each line as a JSON record naming the stream it came from, like
+//  Docker's json-file log driver, e.g. {"stream":"stdout","log":"hi\n"}.  A
+//  line that is not valid UTF-8 goes base64 encoded in "data" instead of "log".
+//  A non-empty level is added as "level".  A piece of a line longer than max is
+//  marked "partial", as its line continues in the next record.
+//
+type streamTagger struct {
+	w      io.Writer
+	stream string
+	level  string
+	max    int
+}
+
+func (t *streamTagger) writeLine(line []byte) {
+	var buf bytes.Buffer
+
+	record := struct {
+		Stream  string `json:"stream"`
+		Level   string `json:"level,omitempty"`
+		Log     string `json:"log,omitempty"`
+		Data    []byte `json:"data,omitempty"`
+		Partial bool   `json:"partial,omitempty"`
+	}{Stream: t.stream, Level: t.level}
+
+	record.Partial = len(line) == t.max && !bytes.HasSuffix(line, []byte("\n"))
+
+	if utf8.Valid(line) {
+		record.Log = string(line)
+	} else {
+		record.Data = line
+	}
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(record)
+
+	t.w.Write(buf.Bytes())
+}
+
+// jsonChecker
+//
+//  Warn, in our log, about each line of the app's output that is not JSON.
+//  Meant as a lineWriter handler, beside the output rather than in its way.
+//  Blank lines are skipped, as are lines too long to reach check whole.
+//
+type jsonChecker struct {
+	max   int  // longest piece the lineWriter passes on
+	count int  // lines seen
+	long  bool // in the middle of a line passed on in pieces
+}
+
+func (c *jsonChecker) check(line []byte) {
+	whole := bytes.HasSuffix(line, []byte("\n"))
+
+	if c.long || (!whole && len(line) == c.max) {
+		c.long = !whole
+		if whole {
+			c.count++
+		}
+		return
+	}
+
+	c.count++
+
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && !json.Valid(trimmed) {
+		log.Printf("Warning: app's stdout line %d is not JSON (%.80q).", c.count, trimmed)
+	}
+}
+
+// pipeGuard
+//
+//  Pass writes on to w until one fails, e.g. with EPIPE once whoever reads our
+//  output has gone, then log that once and discard the rest.  Writes always
+//  succeed, so the app's pipe keeps draining and the app never blocks or sees
+//  a broken pipe.
+//
+type pipeGuard struct {
+	w      io.Writer
+	name   string
+	broken int32
+}
+
+func (g *pipeGuard) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&g.broken) == 0 {
+		if _, err := g.w.Write(p); err != nil && atomic.CompareAndSwapInt32(&g.broken, 0, 1) {
+			log.Printf("Cannot pass on app's %s (%v). Discarding the rest.", g.name, err)
+		}
+	}
+
+	return len(p), nil
+}
+
+// appOutput
+//
+//  Give the app pipes of our own for output that goes to a writer rather than
+//  a file, and copy from them to the writers.  exec would copy for us, but its
+//  Wait then also waits for the copies, which a descendant holding the app's
+//  output open drags out.  This way the app's exit and the end of its output
+//  are awaited apart.
+//
+type appOutput struct {
+	readers []*os.File
+	writers []*os.File
+	copies  sync.WaitGroup
+}
+
+// pipe returns what the app should write to for its output to reach w.
+func (o *appOutput) pipe(w io.Writer) (io.Writer, error) {
+	if _, ok := w.(*os.File); ok || w == nil {
+		return w, nil
+	}
+
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	o.readers = append(o.readers, r)
+	o.writers = append(o.writers, pw)
+	o.copies.Add(1)
+
+	go func() {
+		defer o.copies.Done()
+		io.Copy(w, r)
+	}()
+
+	return pw, nil
+}
+
+// closeWriters closes our copies of the app's ends, once it has its own.
+func (o *appOutput) closeWriters() {
+	for _, w := range o.writers {
+		w.Close()
+	}
+
+	o.writers = nil
+}
+
+// drain waits for the copies to reach the end of the app's output, or for
+// timeout, after which the rest is cut off.
+func (o *appOutput) drain(timeout time.Duration) {
+	done := make(chan struct{})
+
+	go func() {
+		o.copies.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		log.Printf("App output still open %v after app exited. Closing it.", timeout)
+	}
+
+	for _, r := range o.readers {
+		r.Close()
+	}
+
+	<-done
+}
+
+// countingWriter
+//
+//  Pass writes on to w, counting the bytes and lines that went through.  Safe
+//  for concurrent use, as exec copies stdout and stderr from goroutines.
+//
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+	lines int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+
+	atomic.AddInt64(&c.bytes, int64(n))
+	atomic.AddInt64(&c.lines, int64(bytes.Count(p[:n], []byte("\n"))))
+
+	return n, err
+}
+
+// String gives the counts so far, e.g. "120 bytes in 3 lines".
+func (c *countingWriter) String() string {
+	return fmt.Sprintf("%d bytes in %d lines", atomic.LoadInt64(&c.bytes), atomic.LoadInt64(&c.lines))
+}
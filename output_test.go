@@ -0,0 +1,204 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// byteWriter writes one byte at a time, yielding in between, so writes that
+// are not serialized interleave.
+type byteWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.buf.WriteByte(b)
+		runtime.Gosched()
+	}
+
+	return len(p), nil
+}
+
+// hammer has writers goroutines each write lines lines of its own to w, a
+// whole line per Write, and returns the lines written.
+func hammer(w func(p []byte), writers int, lines int) map[string]bool {
+	var wg sync.WaitGroup
+
+	want := make(map[string]bool)
+
+	for i := 0; i < writers; i++ {
+		for j := 0; j < lines; j++ {
+			want[fmt.Sprintf("writer %d line %d %s", i, j, strings.Repeat("x", j%50))] = true
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < lines; j++ {
+				w([]byte(fmt.Sprintf("writer %d line %d %s\n", i, j, strings.Repeat("x", j%50))))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	return want
+}
+
+// checkLines fails t unless out holds each of want once, whole.
+func checkLines(t *testing.T, out string, want map[string]bool) {
+	t.Helper()
+
+	got := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+	if len(got) != len(want) {
+		t.Errorf("got %d lines; want %d", len(got), len(want))
+	}
+
+	for _, line := range got {
+		if !want[line] {
+			t.Fatalf("torn line (%q)", line)
+		}
+	}
+}
+
+func TestSyncWriterConcurrent(t *testing.T) {
+	var out byteWriter
+
+	w := newSyncWriter(&out)
+
+	want := hammer(func(p []byte) { w.Write(p) }, 8, 200)
+
+	checkLines(t, out.buf.String(), want)
+}
+
+func TestLineWriterConcurrent(t *testing.T) {
+	var out byteWriter
+
+	shared := newSyncWriter(&out)
+	lines := newLineWriter(MAX_LINE, func(line []byte) { shared.Write(line) })
+
+	// the app's lines, through the lineWriter, race our log on shared.
+	want := hammer(func(p []byte) {
+		if bytes.HasPrefix(p, []byte("writer 0 ")) {
+			shared.Write(p)
+		} else {
+			lines.Write(p)
+		}
+	}, 8, 200)
+	lines.Flush()
+
+	checkLines(t, out.buf.String(), want)
+}
+
+func TestCountingWriterConcurrent(t *testing.T) {
+	var out byteWriter
+
+	counter := &countingWriter{w: newSyncWriter(&out)}
+
+	want := hammer(func(p []byte) { counter.Write(p) }, 8, 200)
+	checkLines(t, out.buf.String(), want)
+
+	if got := counter.String(); got != fmt.Sprintf("%d bytes in %d lines", out.buf.Len(), len(want)) {
+		t.Errorf("got %s; want %d bytes in %d lines", got, out.buf.Len(), len(want))
+	}
+}
+
+func TestLevelColorWriter(t *testing.T) {
+	var out bytes.Buffer
+
+	// wired as for --colorize-child-levels, one line per Write.
+	colored := levelColorWriter{&out}
+	lines := newLineWriter(MAX_LINE, func(line []byte) { colored.Write(line) })
+
+	for _, p := range []string{"ERROR disk full\n", "[WARN] disk low\n", "info: disk ok\n", "disk checked\n", "ERR", "OR split\n"} {
+		lines.Write([]byte(p))
+	}
+	lines.Flush()
+
+	want := "\x1b[31mERROR disk full\x1b[0m\n" +
+		"\x1b[33m[WARN] disk low\x1b[0m\n" +
+		"\x1b[32minfo: disk ok\x1b[0m\n" +
+		"disk checked\n" +
+		"\x1b[31mERROR split\x1b[0m\n"
+
+	if out.String() != want {
+		t.Errorf("got %q; want %q", out.String(), want)
+	}
+}
+
+func TestLongLineIsSplit(t *testing.T) {
+	var out bytes.Buffer
+
+	line := strings.Repeat("x", 5<<20) + "\n"
+	tagger := &streamTagger{w: &out, stream: "stdout", max: MAX_LINE}
+	lines := newLineWriter(MAX_LINE, tagger.writeLine)
+
+	// in uneven writes, as a pipe would hand them out.
+	for rest := line; rest != ""; {
+		n := len(rest)
+		if n > 100000 {
+			n = 100000
+		}
+
+		lines.Write([]byte(rest[:n]))
+		rest = rest[n:]
+	}
+	lines.Flush()
+
+	var (
+		got     strings.Builder
+		records int
+	)
+
+	dec := json.NewDecoder(&out)
+
+	for dec.More() {
+		var record struct {
+			Log     string `json:"log"`
+			Partial bool   `json:"partial"`
+		}
+
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("bad record: %v", err)
+		}
+
+		if len(record.Log) > MAX_LINE {
+			t.Fatalf("record of %d bytes; want at most %d", len(record.Log), MAX_LINE)
+		}
+
+		last := strings.HasSuffix(record.Log, "\n")
+		if record.Partial == last {
+			t.Errorf("record %d: partial %v at line end %v", records, record.Partial, last)
+		}
+
+		got.WriteString(record.Log)
+		records++
+	}
+
+	if got.String() != line {
+		t.Errorf("records join to %d bytes; want the %d byte line", got.Len(), len(line))
+	}
+
+	if want := (len(line) + MAX_LINE - 1) / MAX_LINE; records != want {
+		t.Errorf("got %d records; want %d", records, want)
+	}
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// environment variable that marks a child as the exec helper and carries
+	// its preExecConfig.
+	preExecEnv = "DOCKER_RUN_APP_PRE_EXEC"
+
+	PR_SET_NO_NEW_PRIVS = 38
+)
+
+// restrictions applied by the exec helper to itself before exec'ing the app.
+type preExecConfig struct {
+	NoNewPrivileges  bool   `json:",omitempty"`
+	SeccompProfile   string `json:",omitempty"`
+	DropCapabilities []uint `json:",omitempty"`
+	MaxOpenFiles     uint64 `json:",omitempty"`
+	ListenPid        bool   `json:",omitempty"`
+}
+
+// setupPreExec
+//
+//  Go cannot run code between fork and exec, so when the app must be
+//  restricted, we start ourselves instead (the exec helper), apply the
+//  restrictions, and exec the app in place.  The app keeps the pid we
+//  signal.
+//
+func setupPreExec(cmd *exec.Cmd, options map[string]string) error {
+	caps, err := capabilitiesToDrop(options)
+	if err != nil {
+		return err
+	}
+
+	config := preExecConfig{
+		NoNewPrivileges:  options["no-new-privileges"] != "",
+		SeccompProfile:   options["seccomp-profile"],
+		DropCapabilities: caps,
+		ListenPid:        options["pass-fd"] != "",
+	}
+
+	if options["max-open-files"] != "" {
+		if config.MaxOpenFiles, err = checkMaxOpenFiles(options["max-open-files"]); err != nil {
+			return err
+		}
+
+		log.Printf("Limiting app to open files (%d).", config.MaxOpenFiles)
+	}
+
+	if !config.NoNewPrivileges && config.SeccompProfile == "" && len(config.DropCapabilities) == 0 && config.MaxOpenFiles == 0 && !config.ListenPid {
+		return nil
+	}
+
+	// fail here, rather than in the helper, if the profile is unusable.
+	if config.SeccompProfile != "" {
+		if _, err := loadSeccompProfile(config.SeccompProfile); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	cmd.Env = append(env, preExecEnv+"="+string(data))
+	cmd.Args = append([]string{os.Args[0], cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+
+	return nil
+}
+
+// checkMaxOpenFiles parses --max-open-files, which may not exceed our hard limit.
+func checkMaxOpenFiles(value string) (uint64, error) {
+	var limit syscall.Rlimit
+
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("flag --max-open-files has an invalid count (%s)", value)
+	}
+
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, fmt.Errorf("cannot read open files limit: %v", err)
+	}
+
+	if n > limit.Max {
+		return 0, fmt.Errorf("flag --max-open-files (%d) exceeds the hard limit (%d)", n, limit.Max)
+	}
+
+	return n, nil
+}
+
+// runPreExec
+//
+//  Entry point of the exec helper.  os.Args holds the app's path followed by
+//  its argv.  Never returns.
+//
+func runPreExec() {
+	var config preExecConfig
+
+	if err := json.Unmarshal([]byte(os.Getenv(preExecEnv)), &config); err != nil {
+		log.Printf("Cannot read exec helper config (%v).", err)
+		os.Exit(int(CannotStartApp))
+	}
+
+	os.Unsetenv(preExecEnv)
+
+	if len(os.Args) < 3 {
+		log.Println("Exec helper is missing <command>.")
+		os.Exit(int(MissingArgument))
+	}
+
+	// restrictions apply per thread, so exec from the thread we restrict.
+	runtime.LockOSThread()
+
+	// the hard limit stays, so the app may lower, but not raise, it.
+	if config.MaxOpenFiles > 0 {
+		var limit syscall.Rlimit
+
+		err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit)
+		if err == nil {
+			limit.Cur = config.MaxOpenFiles
+			err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit)
+		}
+		if err != nil {
+			log.Printf("Cannot limit open files (%v).", err)
+			os.Exit(int(CannotStartApp))
+		}
+	}
+
+	// before seccomp, which may forbid the syscalls this needs.
+	if len(config.DropCapabilities) > 0 {
+		if err := dropCapabilities(config.DropCapabilities); err != nil {
+			log.Printf("Cannot drop capabilities (%v).", err)
+			os.Exit(int(CannotStartApp))
+		}
+	}
+
+	if config.NoNewPrivileges {
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
+			log.Printf("Cannot set no_new_privs (%v).", errno)
+			os.Exit(int(CannotStartApp))
+		}
+	}
+
+	if config.SeccompProfile != "" {
+		filter, err := loadSeccompProfile(config.SeccompProfile)
+		if err == nil {
+			err = installSeccomp(filter)
+		}
+		if err != nil {
+			log.Printf("Cannot install seccomp profile (%s): %v", config.SeccompProfile, err)
+			os.Exit(int(CannotStartApp))
+		}
+	}
+
+	// the app keeps our pid, which only now is known.
+	if config.ListenPid {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+
+	err := syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+
+	log.Printf("Cannot exec app (%s): %v", os.Args[1], err)
+	os.Exit(int(CannotStartApp))
+}
@@ -0,0 +1,78 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMaxOpenFiles(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--max-open-files", "100", "--", "/bin/sh", "-c", "ulimit -n")
+	if code != int(OK) || stdout != "100\n" {
+		t.Fatalf("got code %d, stdout %q; want 0, \"100\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+	if !strings.Contains(stderr, "Limiting app to open files (100).") {
+		t.Errorf("no limit logged in %q", stderr)
+	}
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	// nothing exceeds an unlimited hard limit.
+	if limit.Max == ^uint64(0) {
+		return
+	}
+
+	_, stderr, code = runMain(t, "", "--max-open-files", strconv.FormatUint(limit.Max+1, 10), "--", "/bin/true")
+	if code != int(BadFlag) || !strings.Contains(stderr, "exceeds the hard limit") {
+		t.Errorf("got code %d; want %d for a count over the hard limit\nstderr: %s", code, BadFlag, stderr)
+	}
+}
+
+func TestNoNewPrivileges(t *testing.T) {
+	stdout, stderr, code := runMain(t, "", "--no-new-privileges", "--", "/bin/sh", "-c", "grep NoNewPrivs /proc/self/status")
+	if fields := strings.Fields(stdout); code != int(OK) || len(fields) != 2 || fields[1] != "1" {
+		t.Errorf("got code %d, stdout %q; want 0, \"NoNewPrivs:\\t1\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+}
+
+func TestSeccompProfile(t *testing.T) {
+	dir := t.TempDir()
+
+	// a single SECCOMP_RET_ALLOW.
+	allowAll := filepath.Join(dir, "allow.json")
+	if err := os.WriteFile(allowAll, []byte(`[{"code": 6, "jt": 0, "jf": 0, "k": 2147418112}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// unprivileged, seccomp needs no_new_privs.
+	stdout, stderr, code := runMain(t, "", "--no-new-privileges", "--seccomp-profile", allowAll, "--", "/bin/echo", "hi")
+	if code != int(OK) || stdout != "hi\n" {
+		t.Errorf("allow all: got code %d, stdout %q; want 0, \"hi\\n\"\nstderr: %s", code, stdout, stderr)
+	}
+
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`[{"code": 6,`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code = runMain(t, "", "--no-new-privileges", "--seccomp-profile", malformed, "--", "/bin/echo", "hi")
+	if code != int(BadFlag) || stdout != "" || !strings.Contains(stderr, "is not a valid JSON BPF program") {
+		t.Errorf("malformed: got code %d, stdout %q; want %d, \"\"\nstderr: %s", code, stdout, BadFlag, stderr)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+const preExecEnv = "DOCKER_RUN_APP_PRE_EXEC"
+
+// setupPreExec fails for any option that needs the Linux exec helper.
+func setupPreExec(cmd *exec.Cmd, options map[string]string) error {
+	for _, name := range []string{"drop-capabilities", "keep-capabilities", "max-open-files", "no-new-privileges", "seccomp-profile"} {
+		if options[name] != "" {
+			return fmt.Errorf("flag --%s is only supported on Linux", name)
+		}
+	}
+
+	return nil
+}
+
+// runPreExec is never needed off Linux.
+func runPreExec() {
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// superviseOnly
+//
+//  Run as a bare init for --allow-no-command: reap every child that exits
+//  until SIGINT or SIGTERM arrives, then return OK.  Orphans are reparented to
+//  us only as PID 1, or with a subreaper set by whoever started us.
+//
+func superviseOnly() AppError {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGCHLD)
+	defer signal.Stop(sigs)
+
+	log.Println("No command given. Reaping orphaned processes until stopped.")
+
+	for sig := range sigs {
+		if sig != syscall.SIGCHLD {
+			log.Printf("Received signal (%v). Exiting.", sig)
+			break
+		}
+
+		reapChildren()
+	}
+
+	return OK
+}
+
+// reapChildren waits on every exited child without blocking.  SIGCHLD is not
+// queued, so one signal may stand for many exits.
+func reapChildren() {
+	var status syscall.WaitStatus
+
+	for {
+		pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+
+		if err != nil || pid <= 0 {
+			return
+		}
+
+		if status.Signaled() {
+			log.Printf("Reaped process (%d), terminated by signal (%v).", pid, status.Signal())
+		} else {
+			log.Printf("Reaped process (%d), exited with code (%d).", pid, status.ExitStatus())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+)
+
+const (
+	NormalExit         ExitReason = iota // app exited on its own
+	SignaledByApp                        // app died from a signal we did not send (e.g. SIGSEGV)
+	StoppedBySignal                      // app stopped during our shutdown sequence
+	KilledAfterTimeout                   // app ignored every stop signal and was killed
+	FailedToStart                        // app never ran
+)
+
+// ExitReason is how a run of the app ended.
+type ExitReason int
+
+// classifyExit
+//
+//  Work out how the run ended.  state is the app's final state, nil if the app
+//  was not waited on.  shutdown is true if we started the shutdown sequence,
+//  and stopErr is what the sequence returned.
+//
+func classifyExit(state *os.ProcessState, shutdown bool, stopErr AppError) ExitReason {
+	switch {
+	case shutdown && stopErr == FailedToKillApp:
+		return KilledAfterTimeout
+	case shutdown:
+		return StoppedBySignal
+	case state == nil:
+		return FailedToStart
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return SignaledByApp
+	}
+
+	return NormalExit
+}
+
+// logExitReason writes the one line summary of how the run ended.
+func logExitReason(reason ExitReason, state *os.ProcessState) {
+	if state != nil {
+		log.Printf("App finished (%v, %v).", reason, state)
+	} else {
+		log.Printf("App finished (%v).", reason)
+	}
+}
+
+// exitDetail says how the app ended: cleanly, with an exit code, or by a signal.
+func exitDetail(state *os.ProcessState) string {
+	if state == nil {
+		return "exit not seen yet"
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return fmt.Sprintf("terminated by signal (%v)", status.Signal())
+	}
+
+	if state.ExitCode() == 0 {
+		return "exited cleanly (code 0)"
+	}
+
+	return fmt.Sprintf("exited with code (%d)", state.ExitCode())
+}
+
+func (reason ExitReason) String() string {
+	switch reason {
+	case NormalExit:
+		return "normal exit"
+	case SignaledByApp:
+		return "signaled by app"
+	case StoppedBySignal:
+		return "stopped by signal"
+	case KilledAfterTimeout:
+		return "killed after timeout"
+	case FailedToStart:
+		return "failed to start"
+	default:
+		return fmt.Sprintf("unknown reason (%d)", int(reason))
+	}
+}
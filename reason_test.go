@@ -0,0 +1,67 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+// processState runs script with /bin/sh and returns how it ended.
+func processState(t *testing.T, script string) *os.ProcessState {
+	t.Helper()
+
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Run()
+
+	if cmd.ProcessState == nil {
+		t.Fatalf("cannot run %q", script)
+	}
+
+	return cmd.ProcessState
+}
+
+func TestClassifyExit(t *testing.T) {
+	clean := processState(t, "exit 0")
+	failed := processState(t, "exit 3")
+	crashed := processState(t, "kill -SEGV $$")
+
+	tests := []struct {
+		name     string
+		state    *os.ProcessState
+		shutdown bool
+		stopErr  AppError
+		reason   ExitReason
+		detail   string
+	}{
+		{"clean exit", clean, false, OK, NormalExit, "exited cleanly (code 0)"},
+		{"failed exit", failed, false, OK, NormalExit, "exited with code (3)"},
+		{"crash", crashed, false, OK, SignaledByApp, "terminated by signal (segmentation fault)"},
+		{"stopped", clean, true, OK, StoppedBySignal, "exited cleanly (code 0)"},
+		{"stopped, still dying", nil, true, OK, StoppedBySignal, "exit not seen yet"},
+		{"killed", nil, true, FailedToKillApp, KilledAfterTimeout, "exit not seen yet"},
+		{"never started", nil, false, OK, FailedToStart, "exit not seen yet"},
+	}
+
+	for _, tt := range tests {
+		if reason := classifyExit(tt.state, tt.shutdown, tt.stopErr); reason != tt.reason {
+			t.Errorf("%s: got reason %v; want %v", tt.name, reason, tt.reason)
+		}
+
+		if detail := exitDetail(tt.state); detail != tt.detail {
+			t.Errorf("%s: got detail %q; want %q", tt.name, detail, tt.detail)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandResponseFiles
+//
+//  Replace each @FILE argument where a flag may go with the arguments read
+//  from FILE, e.g. a long, generated list of flags.  @@ARG stands for a
+//  literal @ARG.  Arguments from the first "--" or COMMAND on, and the
+//  parameters of flags, are left as they are.
+//
+func expandResponseFiles(args []string) ([]string, error) {
+	out, _, err := expandFlagArgs(args, "")
+	return out, err
+}
+
+// expandFlagArgs
+//
+//  Do the work of expandResponseFiles on args, which were read from response
+//  file from, or given on the command line if from is "".  Reports whether
+//  args reached "--" or COMMAND, after which nothing more is expanded.
+//
+func expandFlagArgs(args []string, from string) (out []string, done bool, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case strings.HasPrefix(arg, "@@"):
+			// a literal @ARG is not a flag, so it starts COMMAND.
+			out = append(out, arg[1:])
+			return append(out, args[i+1:]...), true, nil
+		case strings.HasPrefix(arg, "@") && from != "":
+			return nil, false, fmt.Errorf("response file (%s) names another (%s); they cannot be nested", from, arg[1:])
+		case strings.HasPrefix(arg, "@"):
+			words, readErr := readResponseFile(arg[1:])
+			if readErr != nil {
+				return nil, false, readErr
+			}
+
+			expanded, command, expandErr := expandFlagArgs(words, arg[1:])
+			if expandErr != nil {
+				return nil, false, expandErr
+			}
+
+			out = append(out, expanded...)
+
+			if command {
+				return append(out, args[i+1:]...), true, nil
+			}
+		case arg == "--" || !strings.HasPrefix(arg, "-"):
+			return append(out, args[i:]...), true, nil
+		default:
+			out = append(out, arg)
+
+			// keep a flag's parameter, even one starting with @.
+			if info, found := findFlag(arg); found && info.paramCount() > 0 && i+1 < len(args) {
+				i++
+				out = append(out, args[i])
+			}
+		}
+	}
+
+	return out, false, nil
+}
+
+// readResponseFile
+//
+//  Read the arguments in file.  Each line is split into words as by
+//  splitWords, so an argument holding spaces must be quoted.  Blank lines and
+//  lines starting with # are skipped.
+//
+func readResponseFile(file string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response file: %v", err)
+	}
+
+	var args []string
+
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, splitErr := splitWords(line)
+		if splitErr != nil {
+			return nil, fmt.Errorf("response file (%s) line %d: %v", file, n+1, splitErr)
+		}
+
+		args = append(args, words...)
+	}
+
+	return args, nil
+}
@@ -0,0 +1,65 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandResponseFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	write := func(name string, content string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	flags := write("flags", "# generated\n--no-color\n\n--init-log \"my log\"\n")
+	withCommand := write("command", "--no-color\n/bin/echo hi\n")
+	nested := write("nested", "@"+flags+"\n")
+
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"@" + flags, "--", "app"}, []string{"--no-color", "--init-log", "my log", "--", "app"}},
+		{[]string{"--chdir", "/tmp", "@" + flags, "app"}, []string{"--chdir", "/tmp", "--no-color", "--init-log", "my log", "app"}},
+		{[]string{"@" + withCommand, "@" + flags}, []string{"--no-color", "/bin/echo", "hi", "@" + flags}},
+		{[]string{"@@app", "@" + flags}, []string{"@app", "@" + flags}},
+		{[]string{"--", "@" + flags}, []string{"--", "@" + flags}},
+		{[]string{"app", "@" + flags}, []string{"app", "@" + flags}},
+		{[]string{"--init-log", "@log"}, []string{"--init-log", "@log"}},
+	}
+
+	for _, tt := range tests {
+		got, err := expandResponseFiles(tt.args)
+
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("expandResponseFiles(%q) = %q, %v; want %q", tt.args, got, err, tt.want)
+		}
+	}
+
+	if got, err := expandResponseFiles([]string{"@" + filepath.Join(dir, "missing")}); err == nil {
+		t.Errorf("missing response file: got %q; want an error", got)
+	}
+
+	if _, err := expandResponseFiles([]string{"@" + nested}); err == nil || !strings.Contains(err.Error(), "cannot be nested") {
+		t.Errorf("nested response file: got %v; want it rejected", err)
+	}
+}
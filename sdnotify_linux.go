@@ -0,0 +1,161 @@
+//go:build linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relayNotify
+//
+//  Give the app its own NOTIFY_SOCKET, and relay the READY=1 and WATCHDOG=1
+//  messages it sends there to our NOTIFY_SOCKET, if systemd gave us one.
+//  Returns a func that closes and removes the app's socket.
+//
+func relayNotify(cmd *exec.Cmd) (func(), error) {
+	dir, err := os.MkdirTemp("", "docker-run-app-notify-")
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	upstream := os.Getenv("NOTIFY_SOCKET")
+
+	go func() {
+		buf := make([]byte, 4096)
+
+		for {
+			n, _, err := conn.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+
+			if message := notifyMessage(string(buf[:n])); message != "" {
+				sendNotify(upstream, message)
+			}
+		}
+	}()
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = setEnv(env, "NOTIFY_SOCKET", path)
+
+	log.Printf("Relaying app's notifications from socket (%s).", path)
+
+	return func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// notifyStarted
+//
+//  Tell systemd, through our NOTIFY_SOCKET, that we are ready once the app has
+//  started, and feed its watchdog while the app runs if WATCHDOG_USEC asks for
+//  it.  Returns a func that stops the watchdog.
+//
+func notifyStarted() func() {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return func() {}
+	}
+
+	sendNotify(socket, "READY=1")
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return func() {}
+	}
+
+	// the watchdog may be meant for another process.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return func() {}
+	}
+
+	// ping twice per interval, as sd_watchdog_enabled(3) advises.
+	ticker := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+	done := make(chan struct{})
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sendNotify(socket, "WATCHDOG=1")
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// notifyMessage keeps only the READY=1 and WATCHDOG=1 lines of message.
+func notifyMessage(message string) string {
+	var keep []string
+
+	for _, line := range strings.Split(message, "\n") {
+		if line == "READY=1" || line == "WATCHDOG=1" {
+			keep = append(keep, line)
+		}
+	}
+
+	return strings.Join(keep, "\n")
+}
+
+// sendNotify
+//
+//  Send message to the sd_notify socket at path.  A path starting with @ is
+//  in the abstract namespace.  Does nothing if path is "".
+//
+func sendNotify(path string, message string) {
+	if path == "" {
+		return
+	}
+
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Cannot connect to notify socket (%s): %v", path, err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write([]byte(message)); err != nil {
+		log.Printf("Cannot send notification (%s): %v", message, err)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// listenNotify starts a fake systemd notify socket and returns its messages.
+func listenNotify(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("cannot listen on notify socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	messages := make(chan string, 100)
+
+	go func() {
+		buf := make([]byte, 4096)
+
+		for {
+			n, _, err := conn.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	return path, messages
+}
+
+func TestNotifyStarted(t *testing.T) {
+	path, messages := listenNotify(t)
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "100000")
+
+	_, stderr, code := runMain(t, "", "--", "/bin/sleep", "0.3")
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	got := map[string]int{}
+
+loop:
+	for {
+		select {
+		case message := <-messages:
+			got[message]++
+		case <-time.After(100 * time.Millisecond):
+			break loop
+		}
+	}
+
+	if got["READY=1"] != 1 {
+		t.Errorf("got READY=1 %d times; want once\nmessages: %v", got["READY=1"], got)
+	}
+	if got["WATCHDOG=1"] == 0 {
+		t.Errorf("got no WATCHDOG=1 pings\nmessages: %v", got)
+	}
+}
+
+func TestNotifyMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"READY=1", "READY=1"},
+		{"STATUS=starting\nREADY=1\nMAINPID=42", "READY=1"},
+		{"WATCHDOG=1\nREADY=1", "WATCHDOG=1\nREADY=1"},
+		{"STOPPING=1", ""},
+	}
+
+	for _, tt := range tests {
+		if got := notifyMessage(tt.message); got != tt.want {
+			t.Errorf("notifyMessage(%q) = %q; want %q", tt.message, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// relayNotify fails, as sd_notify is only found on Linux.
+func relayNotify(cmd *exec.Cmd) (func(), error) {
+	return nil, errors.New("flag --sd-notify is only supported on Linux")
+}
+
+// notifyStarted does nothing, as sd_notify is only found on Linux.
+func notifyStarted() func() {
+	return func() {}
+}
@@ -0,0 +1,97 @@
+//go:build linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	SECCOMP_MODE_FILTER = 2
+	BPF_MAXINSNS        = 4096
+
+	sockFilterSize = 8 // sizeof(struct sock_filter)
+)
+
+// loadSeccompProfile
+//
+//  Read a seccomp BPF program from file.  The file holds either a JSON array
+//  of instructions, e.g. [{"code": 6, "jt": 0, "jf": 0, "k": 2147418112}], or
+//  raw struct sock_filter records in native byte order.
+//
+func loadSeccompProfile(file string) ([]syscall.SockFilter, error) {
+	var filter []syscall.SockFilter
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read seccomp profile: %v", err)
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err = json.Unmarshal(trimmed, &filter); err != nil {
+			return nil, fmt.Errorf("seccomp profile (%s) is not a valid JSON BPF program: %v", file, err)
+		}
+	} else {
+		if len(data)%sockFilterSize != 0 {
+			return nil, fmt.Errorf("seccomp profile (%s) is not a valid raw BPF program: size %d is not a multiple of %d", file, len(data), sockFilterSize)
+		}
+
+		filter = make([]syscall.SockFilter, len(data)/sockFilterSize)
+
+		for i := range filter {
+			rec := data[i*sockFilterSize:]
+			filter[i] = syscall.SockFilter{
+				Code: binary.NativeEndian.Uint16(rec[0:2]),
+				Jt:   rec[2],
+				Jf:   rec[3],
+				K:    binary.NativeEndian.Uint32(rec[4:8]),
+			}
+		}
+	}
+
+	if len(filter) == 0 || len(filter) > BPF_MAXINSNS {
+		return nil, fmt.Errorf("seccomp profile (%s) must hold 1 to %d instructions, found %d", file, BPF_MAXINSNS, len(filter))
+	}
+
+	return filter, nil
+}
+
+// installSeccomp
+//
+//  Install filter on the calling thread.  The filter survives exec.  The
+//  kernel refuses unless no_new_privs is set or we hold CAP_SYS_ADMIN.
+//
+func installSeccomp(filter []syscall.SockFilter) error {
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)),
+		Filter: &filter[0],
+	}
+
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, syscall.PR_SET_SECCOMP, SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&prog)))
+
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EACCES:
+		return fmt.Errorf("%v (use --no-new-privileges, or run with CAP_SYS_ADMIN)", errno)
+	default:
+		return errno
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseSignal
+//
+//  Look up a signal by name, with or without the SIG prefix and in any case
+//  (e.g. SIGTERM, TERM, term), or by number (e.g. 15).  A number must be that
+//  of a signal known by name on this platform.
+//
+func parseSignal(name string) (os.Signal, error) {
+	key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+
+	if sig, ok := signalNames[key]; ok {
+		return sig, nil
+	}
+
+	if n, err := strconv.Atoi(key); err == nil {
+		for _, sig := range signalNames {
+			if int(sig) == n {
+				return sig, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signal (%s)", name)
+}
+
+// signalName returns the SIG name of sig (e.g. SIGTERM) if known by name on
+// this platform.
+func signalName(sig os.Signal) string {
+	for name, known := range signalNames {
+		if known == sig {
+			return "SIG" + name
+		}
+	}
+
+	return sig.String()
+}
@@ -0,0 +1,48 @@
+//go:build !unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// signals accepted by parseSignal, keyed by name without the SIG prefix.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// isPrivateSignal reports whether sig is meant for docker-run-app alone.
+func isPrivateSignal(sig os.Signal) bool {
+	return false
+}
+
+// watchProcess returns a channel that never closes, as there is no way to
+// poll a process that is not our child.
+func watchProcess(p *os.Process) <-chan struct{} {
+	return make(chan struct{})
+}
+
+// groupProcAttr returns nil, as there are no process groups to start in.
+func groupProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// killGroup kills p alone.
+func killGroup(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"strings"
+)
+
+const (
+	SYSLOG_PORT     = "514"
+	SYSLOG_TAG      = "docker-run-app"
+	SYSLOG_FACILITY = "daemon"
+)
+
+// syslog facilities accepted by --syslog.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// checkSyslogFacility fails if facility is not a syslog facility (e.g. local0).
+func checkSyslogFacility(facility string) error {
+	if _, ok := syslogFacilities[facility]; !ok {
+		return fmt.Errorf("unknown facility (%s)", facility)
+	}
+
+	return nil
+}
+
+// openSyslog
+//
+//  Connect to the syslog named by dest: "syslog:local" for the local daemon,
+//  or "syslog://HOST[:PORT]" over UDP, "syslog+tcp://HOST[:PORT]" over TCP.
+//  Messages go out at info severity, in facility, tagged tag.
+//
+func openSyslog(dest string, facility string, tag string) (io.WriteCloser, error) {
+	var network, addr string
+
+	switch {
+	case dest == "syslog:local":
+	case strings.HasPrefix(dest, "syslog://"):
+		network, addr = "udp", strings.TrimPrefix(dest, "syslog://")
+	case strings.HasPrefix(dest, "syslog+tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(dest, "syslog+tcp://")
+	default:
+		return nil, fmt.Errorf("unknown syslog destination, expected syslog:local, syslog://HOST[:PORT] or syslog+tcp://HOST[:PORT]")
+	}
+
+	if network != "" {
+		if addr == "" {
+			return nil, fmt.Errorf("syslog destination has no host")
+		}
+
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, SYSLOG_PORT)
+		}
+	}
+
+	// checked by parseFlags.
+	priority := syslogFacilities[facility]
+
+	return syslog.Dial(network, addr, syslog.LOG_INFO|priority, tag)
+}
+
+// writeSyslog writes line to w, from openSyslog, at err severity if isErr.
+func writeSyslog(w io.Writer, line []byte, isErr bool) {
+	if writer, ok := w.(*syslog.Writer); ok && isErr {
+		writer.Err(string(line))
+		return
+	}
+
+	w.Write(line)
+}
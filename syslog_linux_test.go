@@ -0,0 +1,142 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenSyslog starts a fake UDP syslog server and returns its address and the
+// messages it gets.
+func listenSyslog(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen for syslog: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	messages := make(chan string, 100)
+
+	go func() {
+		buf := make([]byte, 4096)
+
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	return conn.LocalAddr().String(), messages
+}
+
+// collectSyslog returns the messages that arrive until none has for a while.
+func collectSyslog(messages <-chan string) []string {
+	var got []string
+
+	for {
+		select {
+		case message := <-messages:
+			got = append(got, message)
+		case <-time.After(200 * time.Millisecond):
+			return got
+		}
+	}
+}
+
+func TestInitLogSyslog(t *testing.T) {
+	addr, messages := listenSyslog(t)
+
+	stdout, stderr, code := runMain(t, "", "--init-log", "syslog://"+addr, "--syslog-tag", "web", "--", "/bin/echo", "hi")
+	if code != int(OK) || stdout != "hi\n" || strings.Contains(stderr, "App started.") {
+		t.Fatalf("got code %d, stdout %q, stderr %q; want 0 and the log in syslog only", code, stdout, stderr)
+	}
+
+	got := strings.Join(collectSyslog(messages), "\n")
+
+	for _, want := range []string{"web[", "App started.", "App finished"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("syslog lacks %q; got %q", want, got)
+		}
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("needs root to stand in for /dev/log")
+	}
+	if _, err := os.Stat("/dev/log"); err == nil {
+		t.Skip("a syslog daemon owns /dev/log")
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: "/dev/log", Net: "unixgram"})
+	if err != nil {
+		t.Skipf("cannot listen on /dev/log: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		os.Remove("/dev/log")
+	})
+
+	messages := make(chan string, 100)
+
+	go func() {
+		buf := make([]byte, 4096)
+
+		for {
+			n, _, err := conn.ReadFromUnix(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	stdout, stderr, code := runMain(t, "", "--syslog", "local0", "--syslog-tag", "web", "--syslog-include-app", "--", "/bin/sh", "-c", "echo out; echo err >&2")
+	if code != int(OK) || stdout != "out\n" {
+		t.Fatalf("got code %d, stdout %q; want 0, out\nstderr: %s", code, stdout, stderr)
+	}
+
+	got := strings.Join(collectSyslog(messages), "\n")
+
+	// local0 is facility 16: info is <134>, err is <131>.
+	for _, want := range []string{"<134>", "web[", "App started.", "out", "<131>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("syslog lacks %q; got %q", want, got)
+		}
+	}
+
+	for _, message := range strings.Split(got, "\n") {
+		if strings.HasSuffix(message, ": err") && !strings.HasPrefix(message, "<131>") {
+			t.Errorf("app's stderr line %q is not an error", message)
+		}
+	}
+}
+
+func TestSyslogUnavailable(t *testing.T) {
+	if _, err := os.Stat("/dev/log"); err == nil {
+		t.Skip("a syslog daemon owns /dev/log")
+	}
+
+	stdout, stderr, code := runMain(t, "", "--syslog", "daemon", "--", "/bin/echo", "hi")
+	if code != int(OK) || stdout != "hi\n" || !strings.Contains(stderr, "Warning: cannot open syslog") || !strings.Contains(stderr, "App started.") {
+		t.Errorf("got code %d, stdout %q; want 0 and the log on stderr with a warning\nstderr: %s", code, stdout, stderr)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !linux
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	SYSLOG_TAG      = "docker-run-app"
+	SYSLOG_FACILITY = "daemon"
+)
+
+// checkSyslogFacility accepts any facility.  openSyslog fails anyway.
+func checkSyslogFacility(facility string) error {
+	return nil
+}
+
+// openSyslog fails, as syslog destinations are only supported on Linux.
+func openSyslog(dest string, facility string, tag string) (io.WriteCloser, error) {
+	return nil, errors.New("syslog is only supported on Linux")
+}
+
+// writeSyslog writes line to w.
+func writeSyslog(w io.Writer, line []byte, isErr bool) {
+	w.Write(line)
+}
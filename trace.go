@@ -0,0 +1,111 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// launched is when we started, so the flag-parse span starts there.
+var launched = time.Now()
+
+// tracer writes --trace-fd spans.  nil without the flag.
+var tracer *spanTracer
+
+// spanTracer
+//
+//  Write how long each phase of startup and shutdown took, one JSON object per
+//  line, e.g. {"span":"start","start":"2006-01-02T15:04:05.5Z","duration_ms":1.5}.
+//  A nil *spanTracer writes nothing, so callers need not check for the flag.
+//
+type spanTracer struct {
+	mu  sync.Mutex
+	out *os.File
+}
+
+type spanRecord struct {
+	Span       string  `json:"span"`
+	Start      string  `json:"start"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// openTracer traces to fd, which must be open already, e.g. 3>trace.json.
+func openTracer(fd int) (*spanTracer, error) {
+	out := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+
+	if out == nil {
+		return nil, fmt.Errorf("invalid file descriptor (%d)", fd)
+	}
+
+	if _, err := out.Stat(); err != nil {
+		return nil, fmt.Errorf("file descriptor (%d) is not open", fd)
+	}
+
+	// the app would keep the trace open after we exit.
+	keepFromApp(out)
+
+	return &spanTracer{out: out}, nil
+}
+
+// span records that name ran from start until now.
+func (t *spanTracer) span(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+
+	record, _ := json.Marshal(spanRecord{
+		Span:       name,
+		Start:      start.UTC().Format(time.RFC3339Nano),
+		DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.out.Write(append(record, '\n'))
+}
+
+// begin starts span name now.  Call the returned func when it ends.
+func (t *spanTracer) begin(name string) func() {
+	start := time.Now()
+
+	return func() {
+		t.span(name, start)
+	}
+}
+
+func (t *spanTracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	return t.out.Close()
+}
+
+// firstWriter passes writes on to w, calling first before the first write.
+// Writers sharing once call first only once between them.
+type firstWriter struct {
+	w     io.Writer
+	once  *sync.Once
+	first func()
+}
+
+func (f *firstWriter) Write(p []byte) (int, error) {
+	f.once.Do(f.first)
+
+	return f.w.Write(p)
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import "os"
+
+// usageString returns "", as there is no rusage off unix.
+func usageString(state *os.ProcessState) string {
+	return ""
+}
+
+// selfUsageString returns "", as there is no rusage off unix.
+func selfUsageString() string {
+	return ""
+}
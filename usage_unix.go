@@ -0,0 +1,58 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// usageString
+//
+//  Describe the CPU time and max RSS in state's rusage, or "" if the platform
+//  did not report one.
+//
+func usageString(state *os.ProcessState) string {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok || rusage == nil {
+		return ""
+	}
+
+	return rusageString(rusage)
+}
+
+// selfUsageString describes docker-run-app's own rusage, or "" if unavailable.
+func selfUsageString() string {
+	var rusage syscall.Rusage
+
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &rusage); err != nil {
+		return ""
+	}
+
+	return rusageString(&rusage)
+}
+
+func rusageString(rusage *syscall.Rusage) string {
+	// ru_maxrss is in bytes on darwin, KiB everywhere else.
+	maxRSS := int64(rusage.Maxrss)
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		maxRSS /= 1024
+	}
+
+	return fmt.Sprintf("user %v, system %v, max rss %d KiB",
+		time.Duration(rusage.Utime.Nano()), time.Duration(rusage.Stime.Nano()), maxRSS)
+}
@@ -0,0 +1,55 @@
+//go:build unix
+
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReportUsage(t *testing.T) {
+	burn := `i=0; while [ $i -lt 300000 ]; do i=$((i+1)); done`
+
+	_, stderr, code := runMain(t, "", "--report-usage", "--", "/bin/sh", "-c", burn)
+	if code != int(OK) {
+		t.Fatalf("got code %d; want 0\nstderr: %s", code, stderr)
+	}
+
+	usage := regexp.MustCompile(`(App|Supervisor) resource usage \(user (\S+), system (\S+), max rss (\d+) KiB\)`)
+
+	found := map[string]bool{}
+
+	for _, m := range usage.FindAllStringSubmatch(stderr, -1) {
+		user, userErr := time.ParseDuration(m[2])
+		_, systemErr := time.ParseDuration(m[3])
+		rss, _ := strconv.Atoi(m[4])
+
+		if userErr != nil || systemErr != nil || rss <= 0 {
+			t.Errorf("%s usage has a bad field: %q", m[1], m[0])
+		}
+
+		// only the app burns CPU.
+		if m[1] == "App" && user <= 0 {
+			t.Errorf("app used no user CPU time: %q", m[0])
+		}
+
+		found[m[1]] = true
+	}
+
+	if !found["App"] || !found["Supervisor"] {
+		t.Errorf("got usage for %v; want App and Supervisor\nstderr: %s", found, stderr)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+ *  docker-run-app      run an arbitrary command and forward signals to said command.
+ *  Copyright (c) 2014 Justin Charette <charetjc@gmail.com> (@boxofrox)
+ *                All Rights Reserved
+ *
+ *  This program is free software. It comes without any warranty, to
+ *  the extent permitted by applicable law. You can redistribute it
+ *  and/or modify it under the terms of the Do What the Fuck You Want
+ *  to Public License, Version 2, as published by Sam Hocevar. See
+ *  http://www.wtfpl.net/ for more details.
+ */
+package main
+
+import (
+	"os"
+	"time"
+)
+
+const (
+	WATCH_INTERVAL = time.Second // how often --watch-file is polled
+)
+
+// fileStamp is what we compare to tell that a watched file changed.
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+func stampFile(file string) fileStamp {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileStamp{}
+	}
+
+	return fileStamp{true, info.Size(), info.ModTime().UnixNano()}
+}
+
+// watchFile
+//
+//  Poll file every interval until stop is closed, and send on the returned
+//  channel once the file has changed and then stayed put for a whole interval,
+//  so a burst of writes counts once.  The file may be missing; appearing or
+//  disappearing counts as a change.
+//
+func watchFile(file string, interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last := stampFile(file)
+		pending := false
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if stamp := stampFile(file); stamp != last {
+				last, pending = stamp, true
+				continue
+			}
+
+			if pending {
+				pending = false
+
+				select {
+				case changed <- struct{}{}:
+				default:
+					// the last change is not handled yet.
+				}
+			}
+		}
+	}()
+
+	return changed
+}